@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	ADMIN_SESSION_TTL time.Duration = time.Hour * 12
+	ADMIN_SESSION_COOKIE string = "iwin_session"
+	CSRF_COOKIE string = "iwin_csrf"
+	CSRF_HEADER string = "X-CSRF-Token"
+	MIN_ADMIN_PASSWORD_LENGTH int = 8
+)
+
+/* --- ADMIN PASSWORD --- */
+
+// [admin] Set the admin password of the local UI, only its bcrypt hash is saved. An empty password removes it
+func setAdminPassword(store Store, password string) error {
+	hash := ""
+	if password != "" {
+		if len(password) < MIN_ADMIN_PASSWORD_LENGTH {
+			return ErrPasswordTooShort
+		}
+
+		b, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		hash = string(b)
+	}
+
+	return store.UpdateSettings(func(st *settingsData) error {
+		st.AdminPasswordHash = hash
+		return nil
+	})
+}
+
+// [admin] Read a password from the first line of a reader, eg. the standard input
+func readPassword(in io.Reader) (string, error) {
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+
+/* --- ADMIN SESSIONS --- */
+
+type adminSession struct {
+	PasswordHash	string		// the hash the session was created with, so changing the password ends it
+	ExpiredAt			time.Time
+}
+
+// adminSessions keeps the sessions of the users logged in to the local UI. They are kept in memory only,
+// so everyone has to log in again when the server restarts
+type adminSessions struct {
+	mu					sync.Mutex
+	sessions		map[string]adminSession
+}
+
+// [admin] Create an empty set of admin sessions
+func newAdminSessions() *adminSessions {
+	return &adminSessions{sessions: map[string]adminSession{}}
+}
+
+// [admin] Check the password against the hash and start a session, an empty ID is returned if the password is wrong
+func (s *adminSessions) login(passwordHash, password string) (string, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password))
+	if err != nil {
+		return "", nil
+	}
+
+	id, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for sid, session := range s.sessions {
+		if now.After(session.ExpiredAt) {
+			delete(s.sessions, sid)
+		}
+	}
+	s.sessions[id] = adminSession{PasswordHash: passwordHash, ExpiredAt: now.Add(ADMIN_SESSION_TTL)}
+
+	return id, nil
+}
+
+// [admin] Check whether a session is valid for the current password hash
+func (s *adminSessions) valid(id, passwordHash string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || id == "" {
+		return false
+	}
+	if time.Now().After(session.ExpiredAt) || session.PasswordHash != passwordHash {
+		delete(s.sessions, id)
+		return false
+	}
+
+	return true
+}
+
+// [admin] End a session
+func (s *adminSessions) logout(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, id)
+}
+
+
+/* --- REQUEST CHECKS --- */
+
+// [admin] Get the CSRF token of the browser, a new one is set in a cookie if it has none
+func (app *application) csrfToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	if cookie, err := r.Cookie(CSRF_COOKIE); err == nil && cookie.Value != "" {
+		return cookie.Value, nil
+	}
+
+	token, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+	app.setCookie(w, CSRF_COOKIE, token, 0)
+
+	return token, nil
+}
+
+// [admin] Check that the CSRF token sent with a request matches the one in its cookie
+func checkCSRFToken(r *http.Request) bool {
+	cookie, err := r.Cookie(CSRF_COOKIE)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+
+	token := r.Header.Get(CSRF_HEADER)
+	return subtle.ConstantTimeCompare([]byte(token), []byte(cookie.Value)) == 1
+}
+
+// [admin] Check that the Origin of a request, if any, is the host it was sent to
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return r.Header.Get("Sec-Fetch-Site") != "cross-site"
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// [admin] Check that a request was sent to a name of this PC, so other sites cannot reach the UI by pointing
+// their own domain at this PC (DNS rebinding)
+func (app *application) checkHost(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	host = strings.Trim(strings.ToLower(host), "[]")
+
+	hostName := strings.ToLower(app.hostInfo.HostName)
+	allowed := []string{"localhost", "127.0.0.1", "::1", hostName, hostName + ".local"}
+	if app.hostInfo.IPAddr != nil {
+		allowed = append(allowed, app.hostInfo.IPAddr.String())
+	}
+
+	for _, a := range allowed {
+		if host == a && a != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// [admin] Set a cookie of the local UI, it is deleted when 'maxAge' is negative
+func (app *application) setCookie(w http.ResponseWriter, name, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name: name,
+		Value: value,
+		Path: "/",
+		MaxAge: maxAge,
+		HttpOnly: true,
+		Secure: !app.config.plainHTTP,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
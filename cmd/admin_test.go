@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// [tests] A handler that replies OK, to check whether a middleware let a request through
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func TestSameSiteOnly(t *testing.T) {
+	app, _ := newTestApp(settingsData{})
+	app.hostInfo = HostInfo{HostName: "My-PC", IPAddr: net.ParseIP("192.168.1.10")}
+
+	tests := []struct {
+		name				string
+		method			string
+		host				string
+		origin			string
+		fetchSite		string	// the Sec-Fetch-Site header
+		cookie			string	// the CSRF cookie
+		token				string	// the CSRF header
+		wantStatus	int
+	}{
+		// hosts
+		{"localhost", http.MethodGet, "localhost:8080", "", "", "", "", http.StatusOK},
+		{"loopback address", http.MethodGet, "127.0.0.1:8080", "", "", "", "", http.StatusOK},
+		{"IPv6 loopback address", http.MethodGet, "[::1]:8080", "", "", "", "", http.StatusOK},
+		{"address of this PC", http.MethodGet, "192.168.1.10:8080", "", "", "", "", http.StatusOK},
+		{"name of this PC", http.MethodGet, "my-pc.local:8080", "", "", "", "", http.StatusOK},
+		{"DNS rebinding", http.MethodGet, "attacker.example.com:8080", "", "", "", "", http.StatusForbidden},
+		{"DNS rebinding with a POST", http.MethodPost, "attacker.example.com", "http://attacker.example.com", "", "csrf", "csrf", http.StatusForbidden},
+		{"name of this PC as a subdomain", http.MethodGet, "my-pc.attacker.example.com", "", "", "", "", http.StatusForbidden},
+
+		// origins
+		{"same-origin POST", http.MethodPost, "localhost:8080", "http://localhost:8080", "", "csrf", "csrf", http.StatusOK},
+		{"POST without an origin", http.MethodPost, "localhost:8080", "", "same-origin", "csrf", "csrf", http.StatusOK},
+		{"cross-origin POST", http.MethodPost, "localhost:8080", "http://evil.example.com", "", "csrf", "csrf", http.StatusForbidden},
+		{"cross-origin POST to another port", http.MethodPost, "localhost:8080", "http://localhost:9090", "", "csrf", "csrf", http.StatusForbidden},
+		{"cross-site POST without an origin", http.MethodPost, "localhost:8080", "", "cross-site", "csrf", "csrf", http.StatusForbidden},
+		{"cross-origin GET", http.MethodGet, "localhost:8080", "http://evil.example.com", "", "", "", http.StatusOK},
+
+		// CSRF tokens
+		{"missing CSRF token", http.MethodPost, "localhost:8080", "http://localhost:8080", "", "csrf", "", http.StatusForbidden},
+		{"incorrect CSRF token", http.MethodPost, "localhost:8080", "http://localhost:8080", "", "csrf", "other", http.StatusForbidden},
+		{"missing CSRF cookie", http.MethodPost, "localhost:8080", "http://localhost:8080", "", "", "csrf", http.StatusForbidden},
+		{"empty CSRF token and cookie", http.MethodPost, "localhost:8080", "http://localhost:8080", "", "", "", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/settings", nil)
+			r.Host = tt.host
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.fetchSite != "" {
+				r.Header.Set("Sec-Fetch-Site", tt.fetchSite)
+			}
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: CSRF_COOKIE, Value: tt.cookie})
+			}
+			if tt.token != "" {
+				r.Header.Set(CSRF_HEADER, tt.token)
+			}
+
+			w := httptest.NewRecorder()
+			app.sameSiteOnly(okHandler).ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestAdminOnly(t *testing.T) {
+	app, _ := newTestApp(settingsData{})
+	app.hostInfo = HostInfo{IPAddr: net.ParseIP("192.168.1.10")}
+
+	request := func(method, remoteAddr, session string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/settings", nil)
+		r.RemoteAddr = remoteAddr
+		if session != "" {
+			r.AddCookie(&http.Cookie{Name: ADMIN_SESSION_COOKIE, Value: session})
+		}
+
+		w := httptest.NewRecorder()
+		app.adminOnly(okHandler).ServeHTTP(w, r)
+		return w
+	}
+
+	// Without a password, only this PC can use the UI
+	if w := request(http.MethodGet, "127.0.0.1:5000", ""); w.Code != http.StatusOK {
+		t.Errorf("got status %d from this PC, want %d", w.Code, http.StatusOK)
+	}
+	if w := request(http.MethodGet, "192.168.1.20:5000", ""); w.Code == http.StatusOK {
+		t.Errorf("got status %d from another PC, want it rejected", w.Code)
+	}
+
+	err := setAdminPassword(app.store, "password1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, _ := app.store.Settings()
+
+	// With a password, a session is needed even on this PC
+	if w := request(http.MethodGet, "127.0.0.1:5000", ""); w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/login" {
+		t.Errorf("got status %d without a session, want a redirect to /login", w.Code)
+	}
+	if w := request(http.MethodPost, "127.0.0.1:5000", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d posting without a session, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if w := request(http.MethodGet, "127.0.0.1:5000", "unknown"); w.Code != http.StatusSeeOther {
+		t.Errorf("got status %d with an unknown session, want %d", w.Code, http.StatusSeeOther)
+	}
+
+	wrong, err := app.adminSessions.login(st.AdminPasswordHash, "password2")
+	if err != nil || wrong != "" {
+		t.Fatalf("got session %q and error %v with a wrong password, want none", wrong, err)
+	}
+
+	session, err := app.adminSessions.login(st.AdminPasswordHash, "password1")
+	if err != nil || session == "" {
+		t.Fatalf("got session %q and error %v, want a session", session, err)
+	}
+	if w := request(http.MethodPost, "192.168.1.20:5000", session); w.Code != http.StatusOK {
+		t.Errorf("got status %d with a session from another PC, want %d", w.Code, http.StatusOK)
+	}
+
+	// An expired session is ended
+	app.adminSessions.mu.Lock()
+	app.adminSessions.sessions[session] = adminSession{PasswordHash: st.AdminPasswordHash, ExpiredAt: time.Now().Add(-time.Second)}
+	app.adminSessions.mu.Unlock()
+	if w := request(http.MethodPost, "127.0.0.1:5000", session); w.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d with an expired session, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if _, ok := app.adminSessions.sessions[session]; ok {
+		t.Errorf("the expired session was kept")
+	}
+
+	// Changing the password ends the sessions of the old one
+	session, _ = app.adminSessions.login(st.AdminPasswordHash, "password1")
+	err = setAdminPassword(app.store, "password2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := request(http.MethodGet, "127.0.0.1:5000", session); w.Code != http.StatusSeeOther {
+		t.Errorf("got status %d with a session of the old password, want %d", w.Code, http.StatusSeeOther)
+	}
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"net/http"
 	"strings"
@@ -10,75 +14,134 @@ import (
 
 const AUTH_SCHEMA string = "Basic "
 
-// [auth] Save a token to the token file
-func readTokens() (TokenList, error) {
-	var tokens TokenList
-	
-	err := readJSONFile(&tokens, TOKENS_FILE_PATH)
-	if err != nil {
-		return tokens, err
-	}
+/* --- TOKEN MODES --- */
 
-	return tokens, nil
-}
+const (
+	TOKEN_MODE_SINGLE string = "single"		// a token allows one upload
+	TOKEN_MODE_SESSION string = "session"	// a token allows uploads for a window or a number of uploads
+)
 
-// [auth] Save a token to the token file
-func saveToken(t Token) error {
-	tokens, err := readTokens()
-	if err != nil {
-		return err
+// How long a single-use token lives, and the default length of a session
+const (
+	SINGLE_TOKEN_TTL = time.Minute * 5
+	DEFAULT_SESSION_MINUTES int = 30
+)
+
+// [auth] Get how long a new token lives and how many uploads it allows (0 means no limit) based on the settings
+func tokenPolicy(st settingsData) (ttl time.Duration, maxUses int) {
+	if st.TokenMode != TOKEN_MODE_SESSION {
+		return SINGLE_TOKEN_TTL, 1
+	}
+
+	minutes := st.SessionMinutes
+	if minutes <= 0 {
+		minutes = DEFAULT_SESSION_MINUTES
 	}
 
-	// Append a token to the list
-	tokens.Tokens = append(tokens.Tokens, t)
+	return time.Minute * time.Duration(minutes), max(st.SessionUploads, 0)
+}
 
-	err = writeJSONFile(tokens, TOKENS_FILE_PATH)
+// [auth] Create a token for the device with identifier 'deviceId' that allows 'maxUses' uploads (0 means no limit),
+// only the salted hash of the secret is kept
+func newToken(deviceId, secret string, expiredAt time.Time, maxUses int) (Token, error) {
+	salt, hash, err := hashSecret(secret)
 	if err != nil {
-		return err
+		return Token{}, err
 	}
 
-	return nil
+	return Token{
+		DeviceId: deviceId,
+		Salt: salt,
+		Hash: hash,
+		ExpiredAt: expiredAt,
+		MaxUses: maxUses,
+	}, nil
+}
+
+// [auth] Save a token to the token file
+func saveToken(store Store, t Token) error {
+	return store.UpdateTokens(func(tokens *TokenList) error {
+		// Append a token to the list
+		tokens.Tokens = append(tokens.Tokens, t)
+		return nil
+	})
 }
 
 // [auth] Validate a given token if it is matched with any token in the list and not expired
-func verifyToken(r *http.Request) (ok bool, err error) {
+func verifyToken(store Store, r *http.Request) (ok bool, err error) {
 	// Extract the authorization header
 	id, secret, err := extractAuthHeader(r)
 	if err != nil {
 		return false, err
 	}
 
-	// Get current tokens in the server
-	tokens, err := readTokens()
+	// Validate the token and save the updated token list back
+	err = store.UpdateTokens(func(tokens *TokenList) error {
+		// Create a new token list for saving updated tokens back
+		newTokens := []Token{}
+
+		now := time.Now()
+		for _, token := range tokens.Tokens {
+			// Drop expired tokens
+			if !now.Before(token.ExpiredAt) {
+				continue
+			}
+			// Compare client's secret and saved secret hash
+			// If the secret is the same, then they pass the authentication,
+			// the sharing is accepted and the token is used once
+			if(!ok && id == token.DeviceId && checkSecret(secret, token.Salt, token.Hash)) {
+				ok = true
+				token.Uses++
+				if token.MaxUses > 0 && token.Uses >= token.MaxUses {
+					continue
+				}
+			}
+			// Update the token list
+			newTokens = append(newTokens, token)
+		}
+		if !ok {
+			return ErrInvalidToken
+		}
+
+		tokens.Tokens = newTokens
+		return nil
+	})
+	if errors.Is(err, ErrInvalidToken) {
+		return false, nil
+	}
 	if err != nil {
 		return false, err
 	}
 
-	// Create a new token list for saving updated tokens back
-	newTokens := []Token{}
+	return true, nil
+}
+
+// [auth] Revoke the token in the authorization header, whether it has uploads left or not
+func revokeToken(store Store, r *http.Request) (ok bool, err error) {
+	id, secret, err := extractAuthHeader(r)
+	if err != nil {
+		return false, err
+	}
 
-	// Validate the token
-	ok = false
-	now := time.Now()
-	for _, token := range tokens.Tokens {
-		// Compare client's secret and saved secret
-		// If the secret is the same, then they pass the authentication
-		// and the sharing is accepted
-		if(id == token.DeviceId && secret == token.Secret && now.Before(token.ExpiredAt)) {
-			ok = true
-		}
-		// Update the token list
-		if(now.Before(token.ExpiredAt) && token.Secret != secret) {
+	err = store.UpdateTokens(func(tokens *TokenList) error {
+		newTokens := []Token{}
+		for _, token := range tokens.Tokens {
+			if !ok && id == token.DeviceId && checkSecret(secret, token.Salt, token.Hash) {
+				ok = true
+				continue
+			}
 			newTokens = append(newTokens, token)
 		}
+		if !ok {
+			return ErrInvalidToken
+		}
 
-	}
-	if !ok {
+		tokens.Tokens = newTokens
+		return nil
+	})
+	if errors.Is(err, ErrInvalidToken) {
 		return false, nil
 	}
-
-	// Save updated token list to the file
-	err = writeJSONFile(TokenList{Tokens: newTokens}, TOKENS_FILE_PATH)
 	if err != nil {
 		return false, err
 	}
@@ -86,9 +149,84 @@ func verifyToken(r *http.Request) (ok bool, err error) {
 	return true, nil
 }
 
+// [auth] Validate the device's ID and session secret against an upload session with identifier 'sessionId'
+func verifyUploadSession(store Store, r *http.Request, sessionId string) (session UploadSession, ok bool, err error) {
+	// Extract the authorization header
+	id, secret, err := extractAuthHeader(r)
+	if err != nil {
+		return session, false, err
+	}
+
+	session, err = getUploadSession(store, sessionId)
+	if err != nil {
+		if errors.Is(err, ErrUploadSessionNotFound) {
+			return session, false, nil
+		}
+		return session, false, err
+	}
+
+	if id != session.DeviceId || !checkSecret(secret, session.SecretSalt, session.SecretHash) || time.Now().After(session.ExpiredAt) {
+		return session, false, nil
+	}
+
+	return session, true, nil
+}
+
+// [auth] Remove all expired tokens from the token file
+func purgeExpiredTokens(store Store) (purged int, err error) {
+	err = store.UpdateTokens(func(tokens *TokenList) error {
+		now := time.Now()
+		newTokens := []Token{}
+		for _, token := range tokens.Tokens {
+			if now.Before(token.ExpiredAt) {
+				newTokens = append(newTokens, token)
+			}
+		}
+		purged = len(tokens.Tokens) - len(newTokens)
+		tokens.Tokens = newTokens
+		return nil
+	})
+
+	return purged, err
+}
+
 
 /* --- MISCELLANEOUS --- */
 
+// [auth] Create a salted SHA-256 hash of a secret
+func hashSecret(secret string) (salt, hash string, err error) {
+	b := make([]byte, 16)
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", "", err
+	}
+	salt = hex.EncodeToString(b)
+
+	return salt, saltedHash(salt, secret), nil
+}
+
+// [auth] Check whether a secret matches a salted hash, in constant time
+func checkSecret(secret, salt, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(saltedHash(salt, secret)), []byte(hash)) == 1
+}
+
+// [auth] Get the SHA-256 hash of a salt and a secret as a hex string
+func saltedHash(salt, secret string) string {
+	sum := sha256.Sum256([]byte(salt + secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// [auth] Generate a random URL-safe string from 'n' random bytes
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
 // [auth] Extract id and password from a given authorization header
 func extractAuthHeader(r *http.Request) (id, password string, err error) {
 	// Extract the authorization header
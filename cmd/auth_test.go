@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHashSecret(t *testing.T) {
+	secret := "s3cret-token"
+
+	salt, hash, err := hashSecret(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(hash, secret) {
+		t.Fatalf("got hash %s containing the secret", hash)
+	}
+
+	tests := []struct {
+		name		string
+		secret	string
+		salt		string
+		hash		string
+		want		bool
+	}{
+		{"same secret", secret, salt, hash, true},
+		{"wrong secret", "s3cret-tokeN", salt, hash, false},
+		{"empty secret", "", salt, hash, false},
+		{"wrong salt", secret, salt + "0", hash, false},
+		{"truncated hash", secret, salt, hash[:len(hash)-1], false},
+		{"empty hash", secret, salt, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkSecret(tt.secret, tt.salt, tt.hash); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHashSecretSalt(t *testing.T) {
+	salt1, hash1, err := hashSecret("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	salt2, hash2, err := hashSecret("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each secret gets its own salt, so equal secrets do not have equal hashes
+	if salt1 == salt2 || hash1 == hash2 {
+		t.Errorf("got the same salt or hash twice: %s %s", salt1, hash1)
+	}
+	if !checkSecret("secret", salt2, hash2) || checkSecret("secret", salt1, hash2) {
+		t.Errorf("a hash must match only with its own salt")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// [certs] Load the server's TLS certificate from the data folder, a self-signed one is generated on the first run
+// or when it has expired. The SHA-256 fingerprint of the certificate is returned, so devices can pin it
+func loadOrCreateCertificate(cfg config, hostInfo HostInfo) (tls.Certificate, string, error) {
+	certPath := cfg.dataPath(TLS_CERT_FILE_PATH)
+	keyPath := cfg.dataPath(TLS_KEY_FILE_PATH)
+
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err == nil {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return cert, "", err
+		}
+		if time.Now().Before(leaf.NotAfter) {
+			return cert, certFingerprint(cert), nil
+		}
+	} else if !os.IsNotExist(err) {
+		return cert, "", err
+	}
+
+	err = createCertificate(certPath, keyPath, hostInfo)
+	if err != nil {
+		return cert, "", err
+	}
+
+	cert, err = tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return cert, "", err
+	}
+
+	return cert, certFingerprint(cert), nil
+}
+
+// [certs] Generate a self-signed certificate for this PC and save it with its private key
+func createCertificate(certPath, keyPath string, hostInfo HostInfo) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{CommonName: "iWin " + hostInfo.HostName},
+		NotBefore: now.Add(-time.Hour),
+		NotAfter: now.AddDate(10, 0, 0),
+		KeyUsage: x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames: []string{"localhost", hostInfo.HostName},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if hostInfo.IPAddr != nil {
+		template.IPAddresses = append(template.IPAddresses, hostInfo.IPAddr)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(certPath), 0755)
+	if err != nil {
+		return err
+	}
+
+	// The private key is written first, so a certificate is never saved without its key
+	err = os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// [certs] Get the SHA-256 fingerprint of a certificate as a hex string
+func certFingerprint(cert tls.Certificate) string {
+	sum := sha256.Sum256(cert.Certificate[0])
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"sync"
+	"time"
+)
+
+// How long a device has to sign a challenge
+const CHALLENGE_TTL = time.Minute * 1
+
+type connectChallenge struct {
+	DeviceId	string
+	ExpiredAt	time.Time
+}
+
+// connectChallenges keeps the random challenges sent to devices on /challenge. Each challenge can be
+// answered only once, so a sniffed signature cannot be replayed
+type connectChallenges struct {
+	mu						sync.Mutex
+	challenges		map[string]connectChallenge
+}
+
+// [challenges] Create an empty set of challenges
+func newConnectChallenges() *connectChallenges {
+	return &connectChallenges{challenges: map[string]connectChallenge{}}
+}
+
+// [challenges] Generate a new challenge for the device with identifier 'deviceId', and drop expired challenges
+func (c *connectChallenges) issue(deviceId string) (string, error) {
+	challenge, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for ch, cc := range c.challenges {
+		if now.After(cc.ExpiredAt) {
+			delete(c.challenges, ch)
+		}
+	}
+
+	c.challenges[challenge] = connectChallenge{DeviceId: deviceId, ExpiredAt: now.Add(CHALLENGE_TTL)}
+
+	return challenge, nil
+}
+
+// [challenges] Verify that the device signed a challenge issued to it with its registered key. The challenge
+// is removed whether the signature is valid or not
+func (c *connectChallenges) verify(device DeviceInfo, challenge, signature string) error {
+	c.mu.Lock()
+	cc, ok := c.challenges[challenge]
+	delete(c.challenges, challenge)
+	c.mu.Unlock()
+
+	if !ok || cc.DeviceId != device.Identifier || time.Now().After(cc.ExpiredAt) {
+		return ErrInvalidChallenge
+	}
+
+	key, err := decodePublicKey(device.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	if !ed25519.Verify(key, challengeMessage(device.Identifier, challenge), sig) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// [challenges] Get the message a device signs to answer a challenge, it is bound to the device's identifier
+func challengeMessage(deviceId, challenge string) []byte {
+	return []byte("iwin-connect:" + deviceId + ":" + challenge)
+}
+
+// [challenges] Decode a base64-encoded Ed25519 public key sent by a device
+func decodePublicKey(encoded string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, ErrInvalidPublicKey
+	}
+
+	return ed25519.PublicKey(key), nil
+}
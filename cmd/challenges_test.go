@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerifyChallenge(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device := DeviceInfo{Identifier: "dev1", PublicKey: base64.StdEncoding.EncodeToString(public)}
+
+	sign := func(deviceId, challenge string) string {
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(private, challengeMessage(deviceId, challenge)))
+	}
+
+	tests := []struct {
+		name			string
+		issuedTo	string
+		device		DeviceInfo
+		setup			func(c *connectChallenges, challenge string)	// runs before the signature is verified
+		signature	func(challenge string) string
+		wantErr		error
+	}{
+		{
+			name: "valid signature",
+			issuedTo: "dev1",
+			device: device,
+			signature: func(challenge string) string { return sign("dev1", challenge) },
+		},
+		{
+			name: "challenge of another device",
+			issuedTo: "dev2",
+			device: device,
+			signature: func(challenge string) string { return sign("dev1", challenge) },
+			wantErr: ErrInvalidChallenge,
+		},
+		{
+			name: "signed for another device",
+			issuedTo: "dev1",
+			device: device,
+			signature: func(challenge string) string { return sign("dev2", challenge) },
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name: "reused challenge",
+			issuedTo: "dev1",
+			device: device,
+			setup: func(c *connectChallenges, challenge string) {
+				c.verify(device, challenge, sign("dev1", challenge))
+			},
+			signature: func(challenge string) string { return sign("dev1", challenge) },
+			wantErr: ErrInvalidChallenge,
+		},
+		{
+			name: "challenge answered after a wrong signature",
+			issuedTo: "dev1",
+			device: device,
+			setup: func(c *connectChallenges, challenge string) {
+				c.verify(device, challenge, "AAAA")
+			},
+			signature: func(challenge string) string { return sign("dev1", challenge) },
+			wantErr: ErrInvalidChallenge,
+		},
+		{
+			name: "expired challenge",
+			issuedTo: "dev1",
+			device: device,
+			setup: func(c *connectChallenges, challenge string) {
+				cc := c.challenges[challenge]
+				cc.ExpiredAt = time.Now().Add(-time.Second)
+				c.challenges[challenge] = cc
+			},
+			signature: func(challenge string) string { return sign("dev1", challenge) },
+			wantErr: ErrInvalidChallenge,
+		},
+		{
+			name: "malformed key",
+			issuedTo: "dev1",
+			device: DeviceInfo{Identifier: "dev1", PublicKey: "bm90IGEga2V5"},
+			signature: func(challenge string) string { return sign("dev1", challenge) },
+			wantErr: ErrInvalidPublicKey,
+		},
+		{
+			name: "malformed signature",
+			issuedTo: "dev1",
+			device: device,
+			signature: func(challenge string) string { return "not base64!" },
+			wantErr: ErrInvalidSignature,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newConnectChallenges()
+			challenge, err := c.issue(tt.issuedTo)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.setup != nil {
+				tt.setup(c, challenge)
+			}
+
+			err = c.verify(tt.device, challenge, tt.signature(challenge))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUnknownChallenge(t *testing.T) {
+	c := newConnectChallenges()
+
+	err := c.verify(DeviceInfo{Identifier: "dev1"}, "never issued", "")
+	if !errors.Is(err, ErrInvalidChallenge) {
+		t.Errorf("got error %v, want %v", err, ErrInvalidChallenge)
+	}
+}
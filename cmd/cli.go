@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+const USAGE string = `Usage: iwin [flags] [command]
+
+Commands:
+  serve                    start the server (default)
+  devices list             list pending and saved devices
+  devices approve <id>     allow a pending device to share files
+  devices deny <id>        remove a pending device without allowing it
+  devices remove <id>      remove a saved device
+  devices log              print the audit log of approved, denied and removed devices
+  tokens list              list the issued tokens
+  tokens purge             revoke all issued tokens
+  config get dst           print the destination folder
+  config set dst <path>    change the destination folder
+  admin set-password       set the password of the settings page, read from the standard input
+  admin clear-password     remove the password, so only this PC can open the settings page
+
+Flags:
+`
+
+// [cli] Run an administration command on the store used by the server
+func runCommand(store Store, args []string, in io.Reader, out io.Writer) error {
+	switch {
+	case match(args, "devices", "list"):
+		return listDevices(store, out)
+	case match(args, "devices", "approve", "*"):
+		return saveDevice(store, args[2], true, "cli")
+	case match(args, "devices", "deny", "*"):
+		return saveDevice(store, args[2], false, "cli")
+	case match(args, "devices", "remove", "*"):
+		return removeDevice(store, args[2], "cli")
+	case match(args, "devices", "log"):
+		return listAuditLog(store, out)
+	case match(args, "tokens", "list"):
+		return listTokens(store, out)
+	case match(args, "tokens", "purge"):
+		return store.UpdateTokens(func(tokens *TokenList) error {
+			fmt.Fprintf(out, "Revoked %d token(s)\n", len(tokens.Tokens))
+			tokens.Tokens = []Token{}
+			return nil
+		})
+	case match(args, "config", "get", "dst"):
+		st, err := store.Settings()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, st.Dst)
+		return nil
+	case match(args, "config", "set", "dst", "*"):
+		err := checkDirValid(args[3])
+		if err != nil {
+			return fmt.Errorf("invalid destination %s: %w", args[3], err)
+		}
+		return setDstPath(store, args[3])
+	case match(args, "admin", "set-password"):
+		fmt.Fprint(out, "New password: ")
+		password, err := readPassword(in)
+		if err != nil {
+			return err
+		}
+		if password == "" {
+			return ErrPasswordTooShort
+		}
+		return setAdminPassword(store, password)
+	case match(args, "admin", "clear-password"):
+		return setAdminPassword(store, "")
+	}
+
+	return ErrUsage
+}
+
+// [cli] Check whether the arguments match a given pattern, "*" matches any argument
+func match(args []string, pattern ...string) bool {
+	if len(args) != len(pattern) {
+		return false
+	}
+
+	for i, p := range pattern {
+		if p != "*" && p != args[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// [cli] Print the pending and saved devices as a table
+func listDevices(store Store, out io.Writer) error {
+	pdDevices, err := store.PendingDevices()
+	if err != nil {
+		return err
+	}
+
+	svDevices, err := store.Devices()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tSTATUS\tLAST SEEN\tRECEIVED")
+	for _, dv := range pdDevices.Devices {
+		fmt.Fprintf(tw, "%s\t%s\tpending\t-\t-\n", dv.Identifier, dv.Name)
+	}
+	for _, dv := range svDevices.Devices {
+		lastSeen := dv.Activity.LastConnectAt
+		if dv.Activity.LastUploadAt.After(lastSeen) {
+			lastSeen = dv.Activity.LastUploadAt
+		}
+		fmt.Fprintf(tw, "%s\t%s\tsaved\t%s\t%s\n", dv.Identifier, dv.Name, formatDate(lastSeen), formatBytes(dv.Activity.BytesReceived))
+	}
+
+	return tw.Flush()
+}
+
+// [cli] Print the audit log as a table, oldest first
+func listAuditLog(store Store, out io.Writer) error {
+	events, err := store.AuditLog()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tACTION\tID\tNAME\tBY")
+	for _, e := range events {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", e.Time.Local().Format(time.DateTime), e.Action, e.DeviceId, e.DeviceName, e.By)
+	}
+
+	return tw.Flush()
+}
+
+// [cli] Print the issued tokens as a table, secrets are not printed
+func listTokens(store Store, out io.Writer) error {
+	tokens, err := store.Tokens()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEVICE\tEXPIRES\tSTATUS")
+	for _, t := range tokens.Tokens {
+		status := "valid"
+		if now.After(t.ExpiredAt) {
+			status = "expired"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", t.DeviceId, t.ExpiredAt.Format(time.DateTime), status)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+/* --- SERVER CONFIG --- */
+
+type config struct {
+	dataDir			string	// folder of the devices, tokens, uploads and settings files
+	logDir			string	// folder of info.log and errors.log
+	addr				string	// HTTP listen address
+	mDNSPort		int			// port advertised by the mDNS service
+	uiDir				string	// folder to load the UI from instead of the embedded one, used for UI development
+	headless		bool		// turn off the desktop actions (opening URLs and folders, clipboard, notifications)
+	plainHTTP		bool		// serve plain HTTP instead of HTTPS with the self-signed certificate
+}
+
+// configFile is the JSON config file, and also used to collect values from the environment and flags.
+// Empty values are not applied
+type configFile struct {
+	DataDir			string	`json:"data_dir"`
+	LogDir			string	`json:"log_dir"`
+	Addr				string	`json:"addr"`
+	MDNSPort		int			`json:"mdns_port"`
+	UIDir				string	`json:"ui_dir"`
+	Headless		bool		`json:"headless"`
+	PlainHTTP		bool		`json:"plain_http"`
+}
+
+// [config] Load the server config from the defaults, the config file, the environment and the command-line flags,
+// later sources override earlier ones. The remaining command-line arguments are returned
+func loadConfig(args []string) (config, []string, error) {
+	fs := flag.NewFlagSet("iwin", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), USAGE)
+		fs.PrintDefaults()
+	}
+	configPath := fs.String("config", "", "path to the config file (env IWIN_CONFIG)")
+	flags := configFile{}
+	fs.StringVar(&flags.DataDir, "data-dir", "", "folder of the devices, tokens and settings files (env IWIN_DATA_DIR)")
+	fs.StringVar(&flags.LogDir, "log-dir", "", "folder of the log files (env IWIN_LOG_DIR)")
+	fs.StringVar(&flags.Addr, "addr", "", "HTTP listen address (env IWIN_ADDR)")
+	fs.IntVar(&flags.MDNSPort, "mdns-port", 0, "port advertised by the mDNS service (env IWIN_MDNS_PORT)")
+	fs.StringVar(&flags.UIDir, "ui-dir", "", "load the templates and static files from this folder instead of the binary, eg. ./ui (env IWIN_UI_DIR)")
+	fs.BoolVar(&flags.Headless, "headless", false, "turn off opening URLs and folders, the clipboard and notifications (env IWIN_HEADLESS)")
+	fs.BoolVar(&flags.PlainHTTP, "plain-http", false, "serve plain HTTP instead of HTTPS (env IWIN_PLAIN_HTTP)")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return config{}, nil, err
+	}
+
+	cfg, defaultConfigPath, err := defaultConfig()
+	if err != nil {
+		return cfg, nil, err
+	}
+
+	// Apply the config file, it is optional unless it is given explicitly
+	path := *configPath
+	if path == "" {
+		path = os.Getenv("IWIN_CONFIG")
+	}
+	if path == "" {
+		path = defaultConfigPath
+	}
+
+	var file configFile
+	err = readJSONFile(&file, path)
+	if err != nil && !(errors.Is(err, os.ErrNotExist) && path == defaultConfigPath) {
+		return cfg, nil, fmt.Errorf("config: cannot read %s: %w", path, err)
+	}
+	cfg.apply(file)
+
+	// Apply the environment
+	env, err := envConfig()
+	if err != nil {
+		return cfg, nil, err
+	}
+	cfg.apply(env)
+
+	// Apply the flags
+	cfg.apply(flags)
+
+	return cfg, fs.Args(), nil
+}
+
+// [config] Get the default config and config file path. When running from the repository checkout,
+// the configs and logs folders in the working directory are used, otherwise the user's config folder is used
+func defaultConfig() (config, string, error) {
+	cfg := config{
+		addr: ":6789",
+		mDNSPort: 9876,
+	}
+
+	if _, err := os.Stat(filepath.Join("configs", SETTINGS_FILE_PATH)); err == nil {
+		cfg.dataDir = "configs"
+		cfg.logDir = "logs"
+		return cfg, filepath.Join("configs", "iwin.json"), nil
+	}
+
+	userDir, err := os.UserConfigDir()
+	if err != nil {
+		return cfg, "", err
+	}
+
+	cfg.dataDir = filepath.Join(userDir, "iwin")
+	cfg.logDir = filepath.Join(userDir, "iwin", "logs")
+
+	return cfg, filepath.Join(cfg.dataDir, "iwin.json"), nil
+}
+
+// [config] Read the config values from the environment
+func envConfig() (configFile, error) {
+	env := configFile{
+		DataDir: os.Getenv("IWIN_DATA_DIR"),
+		LogDir: os.Getenv("IWIN_LOG_DIR"),
+		Addr: os.Getenv("IWIN_ADDR"),
+		UIDir: os.Getenv("IWIN_UI_DIR"),
+	}
+
+	if port := os.Getenv("IWIN_MDNS_PORT"); port != "" {
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			return env, fmt.Errorf("config: invalid IWIN_MDNS_PORT %q", port)
+		}
+		env.MDNSPort = p
+	}
+
+	if headless := os.Getenv("IWIN_HEADLESS"); headless != "" {
+		h, err := strconv.ParseBool(headless)
+		if err != nil {
+			return env, fmt.Errorf("config: invalid IWIN_HEADLESS %q", headless)
+		}
+		env.Headless = h
+	}
+
+	if plainHTTP := os.Getenv("IWIN_PLAIN_HTTP"); plainHTTP != "" {
+		p, err := strconv.ParseBool(plainHTTP)
+		if err != nil {
+			return env, fmt.Errorf("config: invalid IWIN_PLAIN_HTTP %q", plainHTTP)
+		}
+		env.PlainHTTP = p
+	}
+
+	return env, nil
+}
+
+// [config] Override the config with the non-empty values
+func (cfg *config) apply(values configFile) {
+	if values.DataDir != "" {
+		cfg.dataDir = values.DataDir
+	}
+	if values.LogDir != "" {
+		cfg.logDir = values.LogDir
+	}
+	if values.Addr != "" {
+		cfg.addr = values.Addr
+	}
+	if values.MDNSPort != 0 {
+		cfg.mDNSPort = values.MDNSPort
+	}
+	if values.UIDir != "" {
+		cfg.uiDir = values.UIDir
+	}
+	if values.Headless {
+		cfg.headless = true
+	}
+	if values.PlainHTTP {
+		cfg.plainHTTP = true
+	}
+}
+
+// [config] Get the path of a file in the data folder
+func (cfg config) dataPath(name string) string {
+	return filepath.Join(cfg.dataDir, name)
+}
+
+// [config] Get the folder of the partial files of upload sessions
+func (cfg config) uploadPartsDir() string {
+	return cfg.dataPath(UPLOAD_PARTS_DIR)
+}
+
+// [config] Get the URL of a page on this PC, eg. https://localhost:6789/devices
+func (cfg config) localURL(path string) string {
+	port := "6789"
+	if _, p, err := net.SplitHostPort(cfg.addr); err == nil && p != "" {
+		port = p
+	}
+
+	scheme := "https"
+	if cfg.plainHTTP {
+		scheme = "http"
+	}
+
+	return scheme + "://localhost:" + port + path
+}
+
+
+/* --- DATA FOLDER --- */
+
+// [config] Create the data folder and its files with empty data if they do not exist yet
+func initDataDir(cfg config) error {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "."
+	}
+
+	files := map[string]any{
+		DEVICES_FILE_PATH: DeviceList{Devices: []DeviceInfo{}},
+		PENDING_DEVICES_FILE_PATH: DeviceList{Devices: []DeviceInfo{}},
+		TOKENS_FILE_PATH: TokenList{Tokens: []Token{}},
+		UPLOAD_SESSIONS_FILE_PATH: UploadSessionList{Sessions: []UploadSession{}},
+		HISTORY_FILE_PATH: TransferHistory{Records: []TransferRecord{}},
+		SETTINGS_FILE_PATH: settingsData{
+			Dst: filepath.Join(home, "Downloads"),
+			OnConflict: CONFLICT_RENAME,
+			TokenMode: TOKEN_MODE_SINGLE,
+			SessionMinutes: DEFAULT_SESSION_MINUTES,
+			URLSchemes: urlSchemes(settingsData{}),
+		},
+	}
+
+	for name, data := range files {
+		path := cfg.dataPath(name)
+
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return err
+		}
+
+		_, err = os.Stat(path)
+		if err == nil {
+			continue
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+
+		err = writeJSONFile(data, path)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = os.MkdirAll(cfg.uploadPartsDir(), 0755)
+	if err != nil {
+		return err
+	}
+
+	return os.MkdirAll(cfg.logDir, 0755)
+}
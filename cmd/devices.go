@@ -1,7 +1,33 @@
 package main
 
 import (
+	"errors"
 	"net/http"
+	"slices"
+	"time"
+)
+
+// How many of the latest IP addresses of a device are kept
+const MAX_DEVICE_IPS int = 10
+
+// How long a device waits for approval, and how many devices can wait at once
+const (
+	PENDING_DEVICE_TTL time.Duration = time.Hour * 24
+	MAX_PENDING_DEVICES int = 20
+)
+
+// Registration status reported to a device by /addDevice
+const (
+	DEVICE_PENDING string = "pending"
+	DEVICE_APPROVED string = "approved"
+	DEVICE_DENIED string = "denied"
+)
+
+const (
+	AUDIT_APPROVE string = "approve"
+	AUDIT_DENY string = "deny"
+	AUDIT_REMOVE string = "remove"
+	AUDIT_EXPIRE string = "expire"
 )
 
 // [devices] Parse a requested data from an iOS device into DeviceInfo type
@@ -9,21 +35,24 @@ func (app *application) getClientInfo(r *http.Request) (DeviceInfo, error) {
 	// get requested device's information
 	var client DeviceInfoForm
 	err := app.decodePostFormUrlEncoded(r, &client)
+	device := DeviceInfo{
+		Name: client.Name,
+		Identifier: client.Identifier,
+		PublicKey: client.PublicKey,
+	}
 	if err != nil {
-		return DeviceInfo(client), err
+		return device, err
 	}
 	if client.Identifier == "" || client.Name == "" {
-		return DeviceInfo(client), ErrInvalidFormBody
+		return device, ErrInvalidFormBody
 	}
 
-	return DeviceInfo(client), nil
+	return device, nil
 }
 
 // [devices] Check if the requested iOS device is in the saved list or not
-func checkDeviceExist(client DeviceInfo) (exists bool, err error) {
-	var devices DeviceList
-	
-	err = readJSONFile(&devices, DEVICES_FILE_PATH)
+func checkDeviceExist(store Store, client DeviceInfo) (exists bool, err error) {
+	devices, err := store.Devices()
 	if err != nil {
 		return false, err
 	}
@@ -39,45 +68,158 @@ func checkDeviceExist(client DeviceInfo) (exists bool, err error) {
 	return false, nil
 }
 
-// [devices] Append new device to the pending list
-func savePendingDevice(client DeviceInfo) error {
-	var pdDevices DeviceList
-	err := readJSONFile(&pdDevices, PENDING_DEVICES_FILE_PATH)
+// [devices] Get a saved device with identifier 'id'
+func getSavedDevice(store Store, id string) (DeviceInfo, error) {
+	devices, err := store.Devices()
 	if err != nil {
-		return err
+		return DeviceInfo{}, err
+	}
+
+	for _, device := range devices.Devices {
+		if device.Identifier == id {
+			return device, nil
+		}
 	}
-	newDevice := DeviceInfo(client)
-	pdDevices.Devices = append(pdDevices.Devices, newDevice)
 
-	err = writeJSONFile(pdDevices, PENDING_DEVICES_FILE_PATH)
+	return DeviceInfo{}, ErrDeviceNotFound
+}
+
+// [devices] Get the registration status of a device: approved if it is saved, pending if it waits for approval
+// and denied if its latest request was denied. An empty status is returned otherwise.
+// The approved and pending statuses are only reported to the device with the registered key
+func deviceStatus(store Store, client DeviceInfo) (string, error) {
+	saved, err := getSavedDevice(store, client.Identifier)
+	if err == nil && saved.PublicKey == client.PublicKey {
+		return DEVICE_APPROVED, nil
+	}
+	if err != nil && !errors.Is(err, ErrDeviceNotFound) {
+		return "", err
+	}
+
+	pdDevices, err := store.PendingDevices()
 	if err != nil {
-		return err
+		return "", err
+	}
+	now := time.Now()
+	for _, dv := range pdDevices.Devices {
+		if dv.Identifier == client.Identifier && dv.PublicKey == client.PublicKey && !pendingExpired(dv, now) {
+			return DEVICE_PENDING, nil
+		}
+	}
+
+	events, err := store.AuditLog()
+	if err != nil {
+		return "", err
+	}
+	for i := len(events) - 1; i >= 0; i-- {
+		if events[i].DeviceId == client.Identifier {
+			if events[i].Action == AUDIT_DENY {
+				return DEVICE_DENIED, nil
+			}
+			break
+		}
+	}
+
+	return "", nil
+}
+
+// [devices] Check whether a pending device has waited for longer than PENDING_DEVICE_TTL. Devices sent back by
+// the expiry policy were approved before, so they wait until they are approved or denied again
+func pendingExpired(device DeviceInfo, now time.Time) bool {
+	if !device.Activity.ApprovedAt.IsZero() {
+		return false
+	}
+
+	return now.Sub(device.Activity.RequestedAt) > PENDING_DEVICE_TTL
+}
+
+// [devices] Drop the expired requests from a pending list. Devices added before the request time was recorded
+// start their clock now
+func dropExpiredPending(list *DeviceList, now time.Time) {
+	for i := range list.Devices {
+		if list.Devices[i].Activity.RequestedAt.IsZero() {
+			list.Devices[i].Activity.RequestedAt = now
+		}
 	}
 
-	return nil
+	list.Devices = slices.DeleteFunc(list.Devices, func(dv DeviceInfo) bool {
+		return pendingExpired(dv, now)
+	})
 }
 
-// [devices] Remove a pending device with identifier 'id', and append it on the saved list if allowed
-func saveDevice(id string, isAllowed bool) error {
-	// Get pending devices
-	var pdDeviceList DeviceList
-	err := readJSONFile(&pdDeviceList, PENDING_DEVICES_FILE_PATH)
+// [devices] Check whether the pending list is too full to add a device with identifier 'id',
+// a device that is already waiting replaces its own request
+func pendingQueueFull(store Store, id string) (bool, error) {
+	pdDevices, err := store.PendingDevices()
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	// Remove the selected device from the pending devices
-	var device DeviceInfo
-	newPDDeviceList := DeviceList{Devices: []DeviceInfo{}}
-	for _, dv := range(pdDeviceList.Devices) {
-		if dv.Identifier == id {
-			device = dv
-			continue
+	now := time.Now()
+	waiting := 0
+	for _, dv := range pdDevices.Devices {
+		if dv.Identifier != id && (dv.Activity.RequestedAt.IsZero() || !pendingExpired(dv, now)) {
+			waiting++
 		}
-		newPDDeviceList.Devices = append(newPDDeviceList.Devices, dv)
 	}
 
-	err = writeJSONFile(newPDDeviceList, PENDING_DEVICES_FILE_PATH)
+	return waiting >= MAX_PENDING_DEVICES, nil
+}
+
+// [devices] Add a device to the pending list, replacing an earlier request with the same identifier.
+// Expired requests are dropped, and ErrPendingQueueFull is returned if MAX_PENDING_DEVICES are already waiting
+func savePendingDevice(store Store, client DeviceInfo) error {
+	return store.UpdatePendingDevices(func(pdDevices *DeviceList) error {
+		now := time.Now()
+		dropExpiredPending(pdDevices, now)
+		pdDevices.Devices = slices.DeleteFunc(pdDevices.Devices, func(dv DeviceInfo) bool {
+			return dv.Identifier == client.Identifier
+		})
+		if len(pdDevices.Devices) >= MAX_PENDING_DEVICES {
+			return ErrPendingQueueFull
+		}
+
+		client.Activity.RequestedAt = now
+		pdDevices.Devices = append(pdDevices.Devices, client)
+		return nil
+	})
+}
+
+// [devices] Drop the pending devices that have waited for longer than PENDING_DEVICE_TTL, and get how many were dropped
+func purgeExpiredPendingDevices(store Store) (int, error) {
+	count := 0
+	err := store.UpdatePendingDevices(func(pdDevices *DeviceList) error {
+		before := len(pdDevices.Devices)
+		dropExpiredPending(pdDevices, time.Now())
+		count = before - len(pdDevices.Devices)
+		return nil
+	})
+
+	return count, err
+}
+
+// [devices] Remove a pending device with identifier 'id', and append it on the saved list if allowed.
+// The decision is recorded in the audit log along with who made it
+func saveDevice(store Store, id string, isAllowed bool, by string) error {
+	// Remove the selected device from the pending devices
+	var device DeviceInfo
+	found := false
+	err := store.UpdatePendingDevices(func(pdDeviceList *DeviceList) error {
+		newPDDevices := []DeviceInfo{}
+		for _, dv := range(pdDeviceList.Devices) {
+			if dv.Identifier == id {
+				device = dv
+				found = true
+				continue
+			}
+			newPDDevices = append(newPDDevices, dv)
+		}
+		if !found {
+			return ErrDeviceNotFound
+		}
+		pdDeviceList.Devices = newPDDevices
+		return nil
+	})
 	if err != nil {
 		return err
 	}
@@ -85,44 +227,226 @@ func saveDevice(id string, isAllowed bool) error {
 	// if we allow the device to connect to the server, then add the device to the allowed device list
 	// otherwise, we will not add it
 	if isAllowed {	
-		var deviceList DeviceList
-		err = readJSONFile(&deviceList, DEVICES_FILE_PATH)
+		device.Activity.ApprovedAt = time.Now()
+		err = store.UpdateDevices(func(deviceList *DeviceList) error {
+			// A device saved without a key is replaced, its permissions are kept
+			for _, dv := range deviceList.Devices {
+				if dv.Identifier == device.Identifier && device.Permissions == nil {
+					device.Permissions = dv.Permissions
+				}
+			}
+			deviceList.Devices = slices.DeleteFunc(deviceList.Devices, func(dv DeviceInfo) bool {
+				return dv.Identifier == device.Identifier
+			})
+			deviceList.Devices = append(deviceList.Devices, device)
+			return nil
+		})
 		if err != nil {
 			return err
 		}
-		deviceList.Devices = append(deviceList.Devices, device)
+		return auditDevice(store, AUDIT_APPROVE, device, by)
+	}
 
-		err = writeJSONFile(deviceList, DEVICES_FILE_PATH)
-		if err != nil {
-			return err
+	return auditDevice(store, AUDIT_DENY, device, by)
+}
+
+// [devices] Remove a device with identifier 'id' from the saved list, and record who removed it in the audit log
+func removeDevice(store Store, id string, by string) error {
+	var device DeviceInfo
+	err := store.UpdateDevices(func(list *DeviceList) error {
+		// Remove the selected device from the list
+		newDevices := []DeviceInfo{}
+		for _, dv := range(list.Devices) {
+			if dv.Identifier != id {
+				newDevices = append(newDevices, dv)
+			} else {
+				device = dv
+			}
+		}
+		if len(newDevices) == len(list.Devices) {
+			return ErrDeviceNotFound
 		}
+		list.Devices = newDevices
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return auditDevice(store, AUDIT_REMOVE, device, by)
 }
 
-// [devices] Remove a device with identifier 'id' from the saved list
-func removeDevice(id string) error {
-	// Get the saved devices
-	var list DeviceList
-	err := readJSONFile(&list, DEVICES_FILE_PATH)
+// [devices] Record that a device connected or uploaded from the IP address of a request, failures are only logged
+func (app *application) recordActivity(id string, r *http.Request, uploaded bool, received int64) {
+	err := recordDeviceActivity(app.store, id, remoteIP(r), uploaded, received)
 	if err != nil {
-		return err
+		app.errorLog.Println("Failed to record the activity of the device:", err)
+	}
+}
+
+// [devices] Check whether a saved device has expired under the expiry policy of the settings
+func deviceExpired(device DeviceInfo, st settingsData, now time.Time) bool {
+	activity := device.Activity
+	if activity.ApprovedAt.IsZero() {
+		return false
 	}
 
-	// Remove the selected device from the list
-	newList := DeviceList{Devices: []DeviceInfo{}}
-	for _, dv := range(list.Devices) {
-		if dv.Identifier != id {
-			newList.Devices = append(newList.Devices, dv)
+	if st.DeviceExpiryDays > 0 && now.After(activity.ApprovedAt.AddDate(0, 0, st.DeviceExpiryDays)) {
+		return true
+	}
+
+	if st.DeviceInactiveDays > 0 {
+		lastSeen := activity.ApprovedAt
+		for _, t := range []time.Time{activity.LastConnectAt, activity.LastUploadAt} {
+			if t.After(lastSeen) {
+				lastSeen = t
+			}
+		}
+		if now.After(lastSeen.AddDate(0, 0, st.DeviceInactiveDays)) {
+			return true
 		}
 	}
 
-	// Save the saved device list back
-	err = writeJSONFile(newList, DEVICES_FILE_PATH)
+	return false
+}
+
+// [devices] Move the saved devices that have expired back to the pending list, so they have to be approved again.
+// Their tokens are revoked, and the expired devices are returned
+func expireDevices(store Store) ([]DeviceInfo, error) {
+	st, err := store.Settings()
+	if err != nil {
+		return nil, err
+	}
+
+	devices, err := store.Devices()
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	now := time.Now()
+	expired := []DeviceInfo{}
+	for _, dv := range devices.Devices {
+		// Devices approved before the approval time was recorded start their clock now
+		if dv.Activity.ApprovedAt.IsZero() {
+			dv.Activity.ApprovedAt = now
+		}
+
+		if deviceExpired(dv, st, now) {
+			expired = append(expired, dv)
+		}
+	}
+	isExpired := func(dv DeviceInfo) bool {
+		return slices.ContainsFunc(expired, func(ex DeviceInfo) bool { return ex.Identifier == dv.Identifier })
+	}
+
+	// Add the devices to the pending list before removing them from the saved list, so they are never lost
+	// if saving fails in between
+	if len(expired) > 0 {
+		err = store.UpdatePendingDevices(func(list *DeviceList) error {
+			list.Devices = slices.DeleteFunc(list.Devices, isExpired)
+			for _, dv := range expired {
+				dv.Activity.RequestedAt = now
+				list.Devices = append(list.Devices, dv)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = store.UpdateDevices(func(list *DeviceList) error {
+		for i := range list.Devices {
+			if list.Devices[i].Activity.ApprovedAt.IsZero() {
+				list.Devices[i].Activity.ApprovedAt = now
+			}
+		}
+		list.Devices = slices.DeleteFunc(list.Devices, isExpired)
+		return nil
+	})
+	if err != nil || len(expired) == 0 {
+		return expired, err
+	}
+
+	err = store.UpdateTokens(func(tokens *TokenList) error {
+		tokens.Tokens = slices.DeleteFunc(tokens.Tokens, func(t Token) bool {
+			return slices.ContainsFunc(expired, func(dv DeviceInfo) bool {
+				return dv.Identifier == t.DeviceId
+			})
+		})
+		return nil
+	})
+	if err != nil {
+		return expired, err
+	}
+
+	for _, dv := range expired {
+		err = auditDevice(store, AUDIT_EXPIRE, dv, "policy")
+		if err != nil {
+			return expired, err
+		}
+	}
+
+	return expired, nil
+}
+
+// [devices] Expire the devices under the expiry policy, and ask the user to approve them again if any expired
+func (app *application) expireDevices() ([]DeviceInfo, error) {
+	expired, err := expireDevices(app.store)
+	if err != nil || len(expired) == 0 {
+		return expired, err
+	}
+
+	for _, dv := range expired {
+		app.infoLog.Printf("Device %s (%s) expired and has to be approved again\n", dv.Name, dv.Identifier)
+		app.notifyPage("/devices", EVENT_DEVICE_EXPIRED, map[string]any {"id": dv.Identifier, "name": dv.Name})
 	}
 
-	return nil
-}
\ No newline at end of file
+	return expired, nil
+}
+
+// [devices] Append an event about a device to the audit log
+func auditDevice(store Store, action string, device DeviceInfo, by string) error {
+	return store.AppendAudit(AuditEvent{
+		Time: time.Now(),
+		Action: action,
+		DeviceId: device.Identifier,
+		DeviceName: device.Name,
+		By: by,
+	})
+}
+
+// [devices] Update the activity of a saved device when it connects or uploads, 'received' is the number of bytes
+// it uploaded. Nothing is recorded for unknown devices
+func recordDeviceActivity(store Store, id, ip string, uploaded bool, received int64) error {
+	return store.UpdateDevices(func(list *DeviceList) error {
+		i := slices.IndexFunc(list.Devices, func(dv DeviceInfo) bool {
+			return dv.Identifier == id
+		})
+		if i < 0 {
+			return ErrDeviceNotFound
+		}
+
+		now := time.Now()
+		activity := &list.Devices[i].Activity
+		if uploaded {
+			activity.LastUploadAt = now
+			activity.Uploads++
+			activity.BytesReceived += received
+		} else {
+			activity.LastConnectAt = now
+		}
+
+		// Keep each IP address once, with the latest one last
+		ips := slices.DeleteFunc(slices.Clone(activity.IPs), func(a string) bool {
+			return a == ip
+		})
+		ips = append(ips, ip)
+		if len(ips) > MAX_DEVICE_IPS {
+			ips = ips[len(ips)-MAX_DEVICE_IPS:]
+		}
+		activity.IPs = ips
+
+		return nil
+	})
+}
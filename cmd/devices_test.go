@@ -0,0 +1,253 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+)
+
+// failingStore is a memoryStore whose saved devices cannot be updated
+type failingStore struct {
+	*memoryStore
+}
+
+func (s failingStore) UpdateDevices(fn func(*DeviceList) error) error {
+	return errors.New("disk full")
+}
+
+// [tests] Get the form a device posts to /addDevice, with a new key
+func registerForm(t *testing.T, id, code string) url.Values {
+	t.Helper()
+
+	public, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return url.Values{
+		"name": {"phone"},
+		"identifier": {id},
+		"key": {base64.StdEncoding.EncodeToString(public)},
+		"code": {code},
+	}
+}
+
+func TestRegisterDeviceSavedWithoutKey(t *testing.T) {
+	app, _ := newTestApp(settingsData{})
+	perms := &DevicePermissions{Text: true}
+	app.store.(*memoryStore).devices.Devices = []DeviceInfo{{Name: "phone", Identifier: "dev1", Permissions: perms}}
+
+	code, err := app.pairingCodes.mint(PAIRING_CODE_TTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	form := registerForm(t, "dev1", code)
+	w := postForm(app.addDevice, form)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	err = saveDevice(app.store, "dev1", true, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The device replaces its entry with its key, and keeps its permissions
+	devices, _ := app.store.Devices()
+	if len(devices.Devices) != 1 {
+		t.Fatalf("got %d saved devices, want 1", len(devices.Devices))
+	}
+	if dv := devices.Devices[0]; dv.PublicKey != form.Get("key") || dv.Permissions != perms {
+		t.Errorf("got device %+v, want the new key and the old permissions", dv)
+	}
+
+	// Once it has a key, another key cannot take the identifier
+	code, _ = app.pairingCodes.mint(PAIRING_CODE_TTL)
+	w = postForm(app.addDevice, registerForm(t, "dev1", code))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d for another key, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestExpireDevices(t *testing.T) {
+	now := time.Now()
+	store := newMemoryStore(settingsData{DeviceExpiryDays: 30})
+	store.devices.Devices = []DeviceInfo{
+		{Identifier: "old", Activity: DeviceActivity{ApprovedAt: now.AddDate(0, 0, -40)}},
+		{Identifier: "new", Activity: DeviceActivity{ApprovedAt: now.AddDate(0, 0, -10)}},
+		{Identifier: "legacy"},
+	}
+	store.tokens.Tokens = []Token{{DeviceId: "old"}, {DeviceId: "new"}}
+
+	expired, err := expireDevices(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 1 || expired[0].Identifier != "old" {
+		t.Fatalf("got expired %+v, want only old", expired)
+	}
+
+	devices, _ := store.Devices()
+	if len(devices.Devices) != 2 {
+		t.Fatalf("got %d saved devices, want 2", len(devices.Devices))
+	}
+	for _, dv := range devices.Devices {
+		if dv.Activity.ApprovedAt.IsZero() {
+			t.Errorf("device %s has no approval time, want it to start now", dv.Identifier)
+		}
+	}
+
+	pending, _ := store.PendingDevices()
+	if len(pending.Devices) != 1 || pending.Devices[0].Identifier != "old" || pending.Devices[0].Activity.RequestedAt.IsZero() {
+		t.Errorf("got pending devices %+v, want old requested now", pending.Devices)
+	}
+
+	tokens, _ := store.Tokens()
+	if len(tokens.Tokens) != 1 || tokens.Tokens[0].DeviceId != "new" {
+		t.Errorf("got tokens %+v, want only the token of new", tokens.Tokens)
+	}
+
+	audit, _ := store.AuditLog()
+	if len(audit) != 1 || audit[0].Action != AUDIT_EXPIRE || audit[0].DeviceId != "old" {
+		t.Errorf("got audit log %+v, want old expired", audit)
+	}
+}
+
+func TestExpireDevicesKeepsDevicesWhenSavingFails(t *testing.T) {
+	store := failingStore{newMemoryStore(settingsData{DeviceExpiryDays: 30})}
+	store.devices.Devices = []DeviceInfo{
+		{Identifier: "old", Activity: DeviceActivity{ApprovedAt: time.Now().AddDate(0, 0, -40)}},
+	}
+	store.tokens.Tokens = []Token{{DeviceId: "old"}}
+
+	_, err := expireDevices(store)
+	if err == nil {
+		t.Fatal("got no error")
+	}
+
+	// The device is pending before it is removed from the saved list, so it is never lost
+	pending, _ := store.PendingDevices()
+	if len(pending.Devices) != 1 {
+		t.Errorf("got %d pending devices, want the expired device", len(pending.Devices))
+	}
+
+	devices, _ := store.Devices()
+	tokens, _ := store.Tokens()
+	if len(devices.Devices) != 1 || len(tokens.Tokens) != 1 {
+		t.Errorf("got %d saved devices and %d tokens, want both kept", len(devices.Devices), len(tokens.Tokens))
+	}
+}
+
+func TestAddDevicePendingQueue(t *testing.T) {
+	app, _ := newTestApp(settingsData{})
+
+	register := func(form url.Values) (int, string) {
+		code, err := app.pairingCodes.mint(PAIRING_CODE_TTL)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return addDeviceStatus(app, form, code)
+	}
+
+	// A device that registers again is pending once, and can poll its status without a code
+	form := registerForm(t, "dev1", "")
+	for i := 0; i < 2; i++ {
+		if status, got := register(form); status != http.StatusOK || got != DEVICE_PENDING {
+			t.Fatalf("got status %d and %q, want %q", status, got, DEVICE_PENDING)
+		}
+	}
+	if status, got := addDeviceStatus(app, form, ""); status != http.StatusOK || got != DEVICE_PENDING {
+		t.Errorf("got status %d and %q polling, want %q", status, got, DEVICE_PENDING)
+	}
+	pending, _ := app.store.PendingDevices()
+	if len(pending.Devices) != 1 {
+		t.Errorf("got %d pending devices, want 1", len(pending.Devices))
+	}
+
+	// Another key cannot see the status of the device
+	if _, got := addDeviceStatus(app, registerForm(t, "dev1", ""), ""); got != "" {
+		t.Errorf("got %q for another key, want no status", got)
+	}
+
+	// The status follows the decisions on this PC
+	err := saveDevice(app.store, "dev1", false, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, got := addDeviceStatus(app, form, ""); got != DEVICE_DENIED {
+		t.Errorf("got %q after denying, want %q", got, DEVICE_DENIED)
+	}
+
+	if _, got := register(form); got != DEVICE_PENDING {
+		t.Errorf("got %q asking again with a code, want %q", got, DEVICE_PENDING)
+	}
+	err = saveDevice(app.store, "dev1", true, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, got := addDeviceStatus(app, form, ""); got != DEVICE_APPROVED {
+		t.Errorf("got %q after approving, want %q", got, DEVICE_APPROVED)
+	}
+}
+
+func TestAddDevicePendingQueueFull(t *testing.T) {
+	app, _ := newTestApp(settingsData{})
+
+	// Expired requests do not take a place in the queue
+	now := time.Now()
+	store := app.store.(*memoryStore)
+	for i := 0; i < MAX_PENDING_DEVICES; i++ {
+		requestedAt := now
+		if i == 0 {
+			requestedAt = now.Add(-PENDING_DEVICE_TTL - time.Minute)
+		}
+		store.pendingDevices.Devices = append(store.pendingDevices.Devices,
+			DeviceInfo{Identifier: fmt.Sprint("waiting", i), Activity: DeviceActivity{RequestedAt: requestedAt}})
+	}
+
+	code, _ := app.pairingCodes.mint(PAIRING_CODE_TTL)
+	if status, got := addDeviceStatus(app, registerForm(t, "dev1", ""), code); status != http.StatusOK || got != DEVICE_PENDING {
+		t.Fatalf("got status %d and %q, want the expired request replaced", status, got)
+	}
+
+	pending, _ := app.store.PendingDevices()
+	if len(pending.Devices) != MAX_PENDING_DEVICES || slices.ContainsFunc(pending.Devices, func(dv DeviceInfo) bool { return dv.Identifier == "waiting0" }) {
+		t.Errorf("got %d pending devices, want %d without the expired one", len(pending.Devices), MAX_PENDING_DEVICES)
+	}
+
+	// A full queue rejects new devices without using up their code
+	code, _ = app.pairingCodes.mint(PAIRING_CODE_TTL)
+	if status, _ := addDeviceStatus(app, registerForm(t, "dev2", ""), code); status != http.StatusTooManyRequests {
+		t.Errorf("got status %d with a full queue, want %d", status, http.StatusTooManyRequests)
+	}
+	if err := app.pairingCodes.consume(code); err != nil {
+		t.Errorf("got error %v using the code again, want it unused", err)
+	}
+}
+
+// [tests] Post a registration form with a pairing code to /addDevice, and get the status code and the status of the device
+func addDeviceStatus(app *application, form url.Values, code string) (int, string) {
+	values := url.Values{}
+	for key, value := range form {
+		values[key] = value
+	}
+	values.Set("code", code)
+
+	w := postForm(app.addDevice, values)
+
+	var body struct {
+		Status	string	`json:"status"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &body)
+
+	return w.Code, body.Status
+}
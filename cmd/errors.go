@@ -7,4 +7,25 @@ var (
 	ErrHardwareAddrNotFound = errors.New("http: hardware address not found")
 	ErrMDNSCreation = errors.New("mdns: cannot create a mDNS service")
 	ErrMDNSStarting = errors.New("mdns: cannot start a mDNS service")
+	ErrUsage = errors.New("cli: invalid usage, run iwin -h for help")
+	ErrPendingQueueFull = errors.New("devices: too many devices are waiting for approval")
+	ErrDeviceNotFound = errors.New("devices: device not found")
+	ErrPairingCodeInvalid = errors.New("pairing: invalid pairing code")
+	ErrPairingCodeExpired = errors.New("pairing: pairing code has expired")
+	ErrPairingCodeUsed = errors.New("pairing: pairing code has already been used")
+	ErrInvalidPublicKey = errors.New("auth: invalid device public key")
+	ErrInvalidChallenge = errors.New("auth: invalid or expired challenge")
+	ErrInvalidSignature = errors.New("auth: invalid challenge signature")
+	ErrPermissionDenied = errors.New("permissions: permission denied")
+	ErrURLNotAllowed = errors.New("urls: the URL is not allowed")
+	ErrPasswordTooShort = errors.New("admin: the password must have at least 8 characters")
+	ErrInvalidToken = errors.New("auth: invalid token")
+	ErrUploadSessionNotFound = errors.New("uploads: upload session not found")
+	ErrFileTooLarge = errors.New("uploads: uploaded file exceeds the maximum file size")
+	ErrFileSkipped = errors.New("uploads: file already exists and was skipped")
+	ErrTransferRecordNotFound = errors.New("history: transfer record not found")
+	ErrTransferCancelled = errors.New("transfers: the transfer was cancelled")
+	ErrUploadTooLarge = errors.New("uploads: uploaded data exceeds the declared size")
+	ErrInvalidRule = errors.New("rules: invalid routing rule")
+	ErrRuleNotFound = errors.New("rules: routing rule not found")
 )
\ No newline at end of file
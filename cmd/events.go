@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Types of the events pushed to the open pages
+const (
+	EVENT_DEVICE_PENDING string = "device_pending"
+	EVENT_DEVICE_EXPIRED string = "device_expired"
+	EVENT_CONNECT string = "connect"
+	EVENT_UPLOAD string = "upload"
+	EVENT_URL_PENDING string = "url_pending"
+	EVENT_TRANSFER string = "transfer"
+	EVENT_TRANSFER_DONE string = "transfer_done"
+)
+
+// How often a comment is sent to keep the event streams open
+const EVENTS_KEEPALIVE time.Duration = time.Second * 30
+
+type serverEvent struct {
+	Type		string
+	Data		map[string]any
+}
+
+// eventBroker pushes the server events to the pages subscribed to /events. Slow subscribers miss events
+// instead of blocking the server
+type eventBroker struct {
+	mu						sync.Mutex
+	subscribers		map[chan serverEvent][]string	// the event types each subscriber handles, empty for all
+	closed				bool
+}
+
+// [events] Create an event broker without subscribers
+func newEventBroker() *eventBroker {
+	return &eventBroker{subscribers: map[chan serverEvent][]string{}}
+}
+
+// [events] Subscribe to the events of the given types (all types if empty), the channel is closed when unsubscribing
+// or when the broker is closed
+func (b *eventBroker) subscribe(types []string) (chan serverEvent, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan serverEvent, 16)
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	b.subscribers[ch] = types
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// [events] Send an event to the subscribers that handle its type, and get whether there are any
+func (b *eventBroker) publish(eventType string, data map[string]any) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	event := serverEvent{Type: eventType, Data: data}
+	handled := false
+	for ch, types := range b.subscribers {
+		if len(types) > 0 && !slices.Contains(types, eventType) {
+			continue
+		}
+		handled = true
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+
+	return handled
+}
+
+// [events] Close every subscription, so the event streams end when the server shuts down
+func (b *eventBroker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// [events] Push an event to the open pages. If no open page shows this type of event, the page at 'path'
+// is opened instead, so the user still sees it
+func (app *application) notifyPage(path, eventType string, data map[string]any) {
+	if app.events.publish(eventType, data) {
+		return
+	}
+
+	err := app.platform.OpenURL(app.config.localURL(path))
+	if err != nil {
+		app.errorLog.Printf("Failed to open %s: %v\n", path, err)
+	}
+}
+
+// [events] Write an event in the Server-Sent Events format
+func writeEvent(w http.ResponseWriter, event serverEvent) error {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	return err
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+/* --- COLLISION POLICIES --- */
+
+const (
+	CONFLICT_RENAME string = "rename"
+	CONFLICT_OVERWRITE string = "overwrite"
+	CONFLICT_SKIP string = "skip"
+)
+
+// [files] Get a valid collision policy, renaming is used when the policy is unknown
+func conflictPolicy(policy string) string {
+	switch policy {
+	case CONFLICT_OVERWRITE, CONFLICT_SKIP:
+		return policy
+	default:
+		return CONFLICT_RENAME
+	}
+}
+
+// [files] Check whether a given collision policy is supported
+func isValidConflictPolicy(policy string) bool {
+	return policy == CONFLICT_RENAME || policy == CONFLICT_OVERWRITE || policy == CONFLICT_SKIP
+}
+
+// [files] Get the path to save a file named 'name' in a given folder based on the collision policy.
+// With the rename policy, an empty file is created to reserve the path, so it can be replaced safely
+func reserveFilePath(dir, name, policy string) (string, error) {
+	dst := filepath.Join(dir, name)
+
+	switch conflictPolicy(policy) {
+	case CONFLICT_OVERWRITE:
+		return dst, nil
+	case CONFLICT_SKIP:
+		_, err := os.Stat(dst)
+		if err == nil {
+			return "", ErrFileSkipped
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		return dst, nil
+	}
+
+	// Add a counter suffix until the name is not taken, eg. "photo (1).jpg"
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			return dst, f.Close()
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+		dst = filepath.Join(dir, fmt.Sprintf("%s (%d)%s", base, i, ext))
+	}
+}
+
+// [files] Remove the empty file created by reserveFilePath when the file could not be saved to it.
+// With the other policies the path may be an existing file, so it is kept
+func releaseFilePath(dst, policy string) {
+	if conflictPolicy(policy) == CONFLICT_RENAME {
+		os.Remove(dst)
+	}
+}
+
+
+/* --- FILE NAMES --- */
+
+var reservedFileNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// [files] Make a client-supplied file name safe to be saved in the destination folder
+func sanitizeFileName(name string) string {
+	// Strip any path components, from both Windows and Unix paths
+	name = strings.ReplaceAll(name, "\\", "/")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	// Replace control characters and characters that are not allowed on Windows
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`<>:"|?*`, r) || r == utf8.RuneError {
+			return '_'
+		}
+		return r
+	}, name)
+
+	// Windows does not allow names ending with a space or a dot
+	name = strings.TrimSpace(name)
+	name = strings.TrimRight(name, ". ")
+
+	if name == "" {
+		return "file"
+	}
+
+	// Reserved device names are not allowed even with an extension, eg. "CON.txt"
+	base := strings.ToUpper(strings.TrimSpace(strings.SplitN(name, ".", 2)[0]))
+	if reservedFileNames[base] {
+		name = "_" + name
+	}
+
+	// Keep the name within 255 bytes, preserving the extension
+	if len(name) > 255 {
+		ext := filepath.Ext(name)
+		if len(ext) > 32 {
+			ext = ""
+		}
+		base := name[:len(name)-len(ext)]
+		for len(base)+len(ext) > 255 || !utf8.ValidString(base) {
+			base = base[:len(base)-1]
+		}
+		name = base + ext
+	}
+
+	return name
+}
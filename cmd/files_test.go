@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitizeFileName(t *testing.T) {
+	tests := []struct {
+		name	string
+		raw		string
+		want	string
+	}{
+		// path components
+		{"plain name", "photo.jpg", "photo.jpg"},
+		{"parent folders", "../../x", "x"},
+		{"Windows parent folder", `..\x`, "x"},
+		{"Windows absolute path", `C:\a\b`, "b"},
+		{"Unix absolute path", "/etc/passwd", "passwd"},
+		{"only a folder", "a/", "file"},
+		{"dots", "..", "file"},
+		{"empty", "", "file"},
+
+		// reserved device names
+		{"reserved name", "CON", "_CON"},
+		{"reserved name with an extension", "CON.txt", "_CON.txt"},
+		{"reserved name in lower case", "com1.tar.gz", "_com1.tar.gz"},
+		{"reserved name as a prefix", "CONSOLE.txt", "CONSOLE.txt"},
+
+		// characters
+		{"control characters", "a\x00b\nc\x7f.txt", "a_b_c_.txt"},
+		{"characters not allowed on Windows", `a<b>:"c"|?*.txt`, "a_b___c____.txt"},
+		{"invalid UTF-8", "a\xffb.txt", "a_b.txt"},
+		{"unicode", "รูปภาพ.jpg", "รูปภาพ.jpg"},
+
+		// trailing dots and spaces
+		{"trailing dots", "name...", "name"},
+		{"trailing dots and spaces", "name. . ", "name"},
+		{"surrounding spaces", "  name.txt  ", "name.txt"},
+		{"only dots and spaces", ". . .", "file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeFileName(tt.raw); got != tt.want {
+				t.Errorf("sanitizeFileName(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeLongFileName(t *testing.T) {
+	tests := []struct {
+		name	string
+		raw		string
+		want	string
+	}{
+		{"extension kept", strings.Repeat("a", 300) + ".txt", strings.Repeat("a", 251) + ".txt"},
+		{"character not split", strings.Repeat("é", 200) + ".txt", strings.Repeat("é", 125) + ".txt"},
+		{"long extension dropped", "a." + strings.Repeat("b", 300), "a." + strings.Repeat("b", 253)},
+		{"exactly 255 bytes", strings.Repeat("a", 255), strings.Repeat("a", 255)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sanitizeFileName(tt.raw)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if len(got) > 255 || !utf8.ValidString(got) {
+				t.Errorf("got a name of %d bytes, want a valid name of at most 255 bytes", len(got))
+			}
+		})
+	}
+}
+
+func TestReserveFilePath(t *testing.T) {
+	tests := []struct {
+		name			string
+		existing	[]string
+		policy		string
+		want			string	// empty when the file is skipped
+	}{
+		{"rename free name", nil, CONFLICT_RENAME, "photo.jpg"},
+		{"rename taken name", []string{"photo.jpg"}, CONFLICT_RENAME, "photo (1).jpg"},
+		{"rename next counter", []string{"photo.jpg", "photo (1).jpg", "photo (2).jpg"}, CONFLICT_RENAME, "photo (3).jpg"},
+		{"unknown policy renames", []string{"photo.jpg"}, "keep", "photo (1).jpg"},
+		{"overwrite taken name", []string{"photo.jpg"}, CONFLICT_OVERWRITE, "photo.jpg"},
+		{"skip free name", nil, CONFLICT_SKIP, "photo.jpg"},
+		{"skip taken name", []string{"photo.jpg"}, CONFLICT_SKIP, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range tt.existing {
+				err := os.WriteFile(filepath.Join(dir, name), []byte("existing"), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := reserveFilePath(dir, "photo.jpg", tt.policy)
+			if tt.want == "" {
+				if !errors.Is(err, ErrFileSkipped) {
+					t.Errorf("got %q and error %v, want %v", got, err, ErrFileSkipped)
+				}
+				return
+			}
+			if err != nil || got != filepath.Join(dir, tt.want) {
+				t.Fatalf("got %q and error %v, want %q", got, err, tt.want)
+			}
+
+			// The existing files are never changed
+			for _, name := range tt.existing {
+				data, _ := os.ReadFile(filepath.Join(dir, name))
+				if string(data) != "existing" {
+					t.Errorf("%s was changed", name)
+				}
+			}
+
+			// A renamed path is reserved until the file is saved to it
+			_, err = os.Stat(got)
+			reserved := err == nil && !slices.Contains(tt.existing, tt.want)
+			if reserved != (conflictPolicy(tt.policy) == CONFLICT_RENAME) {
+				t.Errorf("got the path reserved %v with the %s policy", reserved, tt.policy)
+			}
+
+			// Only the reserved empty file is removed, an existing file is kept
+			releaseFilePath(got, tt.policy)
+			_, err = os.Stat(got)
+			if kept := err == nil; kept != slices.Contains(tt.existing, tt.want) {
+				t.Errorf("got %s kept %v after releasing it", tt.want, kept)
+			}
+		})
+	}
+}
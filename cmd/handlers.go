@@ -5,6 +5,10 @@ import (
 	"errors"
 	"net/http"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -19,24 +23,94 @@ func (app *application) addDevice(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if the device is in the saved list or not
-	exists, err := checkDeviceExist(device)
+	// The device must register its long-term key, which is used to sign the challenges on /connect
+	_, err = decodePublicKey(device.PublicKey)
 	if err != nil {
-		app.clientError(w, http.StatusBadRequest)
+		app.response(w, http.StatusBadRequest, map[string]any {
+			"message": "Invalid device key",
+		})
+		return
+	}
+
+	// A device that already registered can call again to poll its status, without a pairing code
+	status, err := deviceStatus(app.store, device)
+	if err != nil {
+		app.serverError(w, err)
 		return
 	}
 
-	// If the device is already in the saved list, then reject the request
-	if exists {
-		response := map[string]any {
+	switch status {
+	case DEVICE_APPROVED:
+		app.response(w, http.StatusOK, map[string]any {
 			"message": "Already connected!",
-		}
-		app.response(w, http.StatusBadRequest, response)
+			"status": status,
+		})
+		return
+	case DEVICE_PENDING:
+		app.response(w, http.StatusOK, map[string]any {
+			"message": "Waiting for device verification...",
+			"status": status,
+		})
+		return
+	}
+
+	// Another device with the same identifier is already saved. A device saved before keys were required registers
+	// like a new device, and replaces its saved entry once it is approved
+	saved, err := getSavedDevice(app.store, device.Identifier)
+	if err != nil && !errors.Is(err, ErrDeviceNotFound) {
+		app.serverError(w, err)
+		return
+	}
+
+	if err == nil && saved.PublicKey != "" {
+		app.response(w, http.StatusBadRequest, map[string]any {
+			"message": "Already connected!",
+		})
+		return
+	}
+
+	// Only a device that scanned the QR code on the settings page can register, a denied device can ask again
+	// with a new code
+	code := r.PostForm.Get("code")
+	if code == "" && status == DEVICE_DENIED {
+		app.response(w, http.StatusOK, map[string]any {
+			"message": "Your device was denied",
+			"status": status,
+		})
+		return
+	}
+
+	// Check the pending list first, so a full list does not use up the pairing code
+	full, err := pendingQueueFull(app.store, device.Identifier)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if full {
+		app.response(w, http.StatusTooManyRequests, map[string]any {
+			"message": "Too many devices are waiting for approval, please try again later",
+		})
+		return
+	}
+
+	err = app.pairingCodes.consume(code)
+	if err != nil {
+		app.authFailed(r)
+		app.errorLog.Printf("Rejected registration of %s from %s: %v\n", device.Identifier, r.RemoteAddr, err)
+		app.response(w, http.StatusForbidden, map[string]any {
+			"message": "Invalid pairing code. Please scan the QR code again.",
+		})
 		return
 	}
 
 	// Add the device to the pending list
-	err = savePendingDevice(device)
+	err = savePendingDevice(app.store, device)
+	if errors.Is(err, ErrPendingQueueFull) {
+		app.response(w, http.StatusTooManyRequests, map[string]any {
+			"message": "Too many devices are waiting for approval, please try again later",
+		})
+		return
+	}
 	if err != nil {
 		app.serverError(w, err)
 		return
@@ -44,16 +118,17 @@ func (app *application) addDevice(w http.ResponseWriter, r *http.Request) {
 
 	app.response(w, http.StatusOK, map[string]any {
 		"message": "Added your device to the pending list. Waiting for device verification...",
+		"status": DEVICE_PENDING,
 	})
 
-	// Open a url to verify the device
-	openURL("http://localhost:6789/devices")
+	// Show the device on the open pages, or open the devices page to verify it
+	app.notifyPage("/devices", EVENT_DEVICE_PENDING, map[string]any {"id": device.Identifier, "name": device.Name})
 
 	app.infoLog.Printf("Request for Registration from %s\n", r.RemoteAddr)
 }
 
-// Handle device connection when a valid device wanted to connect to the server
-func (app *application) connect(w http.ResponseWriter, r *http.Request) {	
+// Handle sending a challenge to a valid device, which it signs with its key to connect to the server
+func (app *application) challenge(w http.ResponseWriter, r *http.Request) {
 	// get requested device's information
 	device, err := app.getClientInfo(r)
 	if err != nil {
@@ -62,26 +137,103 @@ func (app *application) connect(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check if the device is in the saved list or not
-	exists, err := checkDeviceExist(device)
+	exists, err := checkDeviceExist(app.store, device)
 	if err != nil {
 		app.clientError(w, http.StatusBadRequest)
 		return
 	}
-	
-	// If not exist, reject the connection
-	// Otherwise, generate, save a random token and send its secret to the device for authentication
 	if !exists {
 		app.clientError(w, http.StatusBadRequest)
 		return
 	}
 
-	secret := uuid.NewString()	// secret
-	expires := time.Now().Add(time.Minute * 5)	// lives for 5 mins
-	err = saveToken(Token{
-		DeviceId: device.Identifier,
-		Secret: secret,
-		ExpiredAt: expires,
+	challenge, err := app.challenges.issue(device.Identifier)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.response(w, http.StatusOK, map[string]any {
+		"challenge": challenge,
 	})
+}
+
+// Handle device connection when a valid device wanted to connect to the server
+func (app *application) connect(w http.ResponseWriter, r *http.Request) {	
+	// get requested device's information
+	client, err := app.getClientInfo(r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// Get the saved device to verify it with its registered key
+	device, err := getSavedDevice(app.store, client.Identifier)
+	if err != nil {
+		// If not exist, reject the connection
+		if errors.Is(err, ErrDeviceNotFound) {
+			app.clientError(w, http.StatusBadRequest)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	// Devices registered without a key have to register again
+	if device.PublicKey == "" {
+		app.response(w, http.StatusForbidden, map[string]any {
+			"message": "Please register your device again",
+		})
+		return
+	}
+
+	// Verify the signature of the challenge
+	// Then, generate, save a random token and send its secret to the device for authentication
+	err = app.challenges.verify(device, r.PostForm.Get("challenge"), r.PostForm.Get("signature"))
+	if err != nil {
+		app.authFailed(r)
+		app.errorLog.Printf("Rejected connection of %s from %s: %v\n", device.Identifier, r.RemoteAddr, err)
+		app.response(w, http.StatusForbidden, map[string]any {
+			"message": "Invalid signature",
+		})
+		return
+	}
+
+	if !app.allowDevice(w, device.Identifier) {
+		return
+	}
+
+	// Move the device back to the pending list if it has expired, only a device that proved its identity is told
+	expired, err := app.expireDevices()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if slices.ContainsFunc(expired, func(dv DeviceInfo) bool { return dv.Identifier == device.Identifier }) {
+		app.response(w, http.StatusForbidden, map[string]any {
+			"message": "Your device has expired, please wait until it is approved again",
+		})
+		return
+	}
+
+	st, err := app.store.Settings()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// The token allows one upload, or several uploads in session mode
+	ttl, maxUses := tokenPolicy(st)
+	secret := uuid.NewString()	// secret
+	expires := time.Now().Add(ttl)
+	token, err := newToken(device.Identifier, secret, expires, maxUses)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = saveToken(app.store, token)
 	if err != nil {
 		app.serverError(w, err)
 		return
@@ -90,15 +242,40 @@ func (app *application) connect(w http.ResponseWriter, r *http.Request) {
 	app.response(w, http.StatusOK, map[string]any {
 		"message": "I'm ready, let's connect!",
 		"s": base64.StdEncoding.EncodeToString([]byte(secret)),
+		"expires_at": expires,
+		"uploads": maxUses,
 	})
 
+	app.recordActivity(device.Identifier, r, false, 0)
+	app.events.publish(EVENT_CONNECT, map[string]any {"id": device.Identifier, "name": device.Name})
 	app.infoLog.Printf("Connected to %s\n", r.RemoteAddr)
 }
 
+// Handle revoking the token of a device when it finished its uploads
+func (app *application) disconnect(w http.ResponseWriter, r *http.Request) {
+	found, err := revokeToken(app.store, r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if !found {
+		app.authFailed(r)
+		app.response(w, http.StatusBadRequest, map[string]any {"message": "Invalid token"})
+		return
+	}
+
+	app.response(w, http.StatusOK, map[string]any {
+		"message": "Disconnected",
+	})
+
+	app.infoLog.Printf("Disconnected from %s\n", r.RemoteAddr)
+}
+
 // Handle upload request when a valid device uploaded files to the server
 func (app *application) upload(w http.ResponseWriter, r *http.Request) {	
 	// Authenticate the device with its ID and secret
-	found, err := verifyToken(r)
+	found, err := verifyToken(app.store, r)
 	if err != nil {
 		app.clientError(w, http.StatusBadRequest)
 		return
@@ -106,177 +283,1093 @@ func (app *application) upload(w http.ResponseWriter, r *http.Request) {
 	
 	// If not found, then reject the request
 	if !found {
+		app.authFailed(r)
 		app.response(w, http.StatusBadRequest, map[string]any {"message": "Invalid token"})
 		return
 	}
 
-	// Validate the request form
-	err = r.ParseMultipartForm(50 << 20)	// maximum 50MB
+	// Get what the device is allowed to send
+	id, _, err := extractAuthHeader(r)
 	if err != nil {
 		app.clientError(w, http.StatusBadRequest)
 		return
 	}
 
-	// Get the form data
-	var url string // URL sent by the client if any
-	var text string // text sent by the client if any
-	for key, vals := range r.MultipartForm.Value {
-		for _, val := range vals {
-			if key == "url" && val != "" {
-				url = val
-			} else if key == "text" && val != "" {
-				text = val
-			}
-		}
+	if !app.allowDevice(w, id) {
+		return
+	}
+
+	device, err := getSavedDevice(app.store, id)
+	if errors.Is(err, ErrDeviceNotFound) {
+		app.response(w, http.StatusForbidden, map[string]any {"message": "Please register your device again"})
+		return
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
 	}
 
-	var st settingsData
+	// Get the destination folder path to save and the upload limits
+	st, err := app.store.Settings()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
 
-	// Get the destination folder path to save
-	err = readJSONFile(&st, SETTINGS_FILE_PATH)
+	// Stream the request instead of buffering it, so large files are not limited by the server timeouts
+	extendDeadlines(w)
+	if st.MaxRequestSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, st.MaxRequestSize)
+	}
+
+	// Track the progress of the upload, so it can be shown and cancelled on this PC
+	tr := app.transfers.start(w, "", id, device.Name, "", r.ContentLength, 0)
+	defer app.transfers.finish(tr)
+	r.Body = app.transfers.reader(tr, r.Body)
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// Save the files if any and get the form data
+	content, err := saveFiles(mr, st, device, tr)
+	if err != nil && tr.cancelled.Load() {
+		app.response(w, http.StatusConflict, map[string]any {"message": "The transfer was cancelled on the PC"})
+		return
+	}
 	if err != nil {
+		var permErr permissionError
+		if errors.As(err, &permErr) {
+			app.response(w, http.StatusForbidden, map[string]any {"message": permErr.reason})
+			return
+		}
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) || errors.Is(err, ErrFileTooLarge) {
+			app.response(w, http.StatusRequestEntityTooLarge, map[string]any {"message": "Upload is too large"})
+			return
+		}
 		app.serverError(w, err)
 		return
 	}
 
-	// Otherwise, we can open the URL if any
+	app.recordFiles(id, content.Saved, content.Skipped)
+
+	url := content.Values["url"] // URL sent by the client if any
+	text := content.Values["text"] // text sent by the client if any
+
+	// Then, we can open the URL if any, once it passes the URL policy
+	// A blocked URL does not fail the upload, the files and the text are still received
+	urlStatus := ""
 	if url != "" {
-		openURL(url)
+		safeURL, err := checkURL(url, st)
+		var urlErr urlError
+		if errors.As(err, &urlErr) {
+			app.infoLog.Printf("Blocked the URL %q from %s: %s\n", url, id, urlErr.reason)
+			app.recordURL(id, url, URL_BLOCKED)
+			urlStatus = URL_BLOCKED
+		} else {
+			urlStatus = app.openURL(id, safeURL, st.URLConfirm)
+		}
 	}
 
 	// Or copy text to clipboard if any
 	if text != "" {
-		err = copyToClipboard(text)
+		err = app.platform.SetClipboard(text)
 		if err != nil {
+			app.recordText(id, text, TEXT_FAILED)
 			app.serverError(w, err)
 			return
 		}
+		app.recordText(id, text, TEXT_COPIED)
 
 		app.infoLog.Printf("Copied %s to clipboard\n", text)
-	}
 
-	// Or save the files if any
-	if len(r.MultipartForm.File) > 0 { 
-		err = saveFiles(r, st.Dst)
+		err = app.platform.Notify("iWin", "Copied the received text to the clipboard")
 		if err != nil {
-			app.serverError(w, err)
-			return
+			app.errorLog.Println("Failed to notify:", err)
 		}
+	}
 
-		// Open the destination folder
-		openFolder(st.Dst)
+	// Open the folder of the first saved file if any, the routing rules may have saved it outside the destination folder
+	if len(content.Saved) > 0 { 
+		app.revealFolder(filepath.Dir(content.Saved[0].Path))
 	}
 
 	app.response(w, http.StatusOK, map[string]any {
 		"message": "Received all content successfully",
+		"skipped": content.Skipped,
+		"url": urlStatus,
 	})
 
+	app.recordActivity(id, r, true, content.Bytes)
+	app.events.publish(EVENT_UPLOAD, map[string]any {
+		"id": id,
+		"name": device.Name,
+		"files": len(content.Saved),
+		"skipped": len(content.Skipped),
+		"bytes": content.Bytes,
+	})
 	app.infoLog.Printf("Uploaded from %s\n", r.RemoteAddr)
 }
 
 
-/* --- DEVICES --- */
+/* --- RESUMABLE UPLOADS --- */
 
-// Handle displaying all devices on the devices page
-func (app *application) getDevices(w http.ResponseWriter, r *http.Request) {
-	var pdDevices DeviceList
-	var svDevices DeviceList
-	
-	// Get pending devices
-	err := readJSONFile(&pdDevices, PENDING_DEVICES_FILE_PATH)
+// Handle creating a resumable upload session when a valid device wanted to upload a large file in chunks
+func (app *application) createUploadSession(w http.ResponseWriter, r *http.Request) {
+	// Authenticate the device with its ID and secret
+	found, err := verifyToken(app.store, r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// If not found, then reject the request
+	if !found {
+		app.authFailed(r)
+		app.response(w, http.StatusBadRequest, map[string]any {"message": "Invalid token"})
+		return
+	}
+
+	deviceId, _, err := extractAuthHeader(r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if !app.allowDevice(w, deviceId) {
+		return
+	}
+
+	// Get the file information
+	var form uploadSessionForm
+	err = app.decodePostFormUrlEncoded(r, &form)
+	if err != nil || form.Name == "" || form.Size <= 0 {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// Check whether the device is allowed to send this file
+	device, err := getSavedDevice(app.store, deviceId)
+	if errors.Is(err, ErrDeviceNotFound) {
+		app.response(w, http.StatusForbidden, map[string]any {"message": "Please register your device again"})
+		return
+	}
 	if err != nil {
 		app.serverError(w, err)
 		return
 	}
 
-	// Get saved devices
-	err = readJSONFile(&svDevices, DEVICES_FILE_PATH)
+	err = device.Perms().checkUpload(sanitizeFileName(form.Name), form.Size)
+	var permErr permissionError
+	if errors.As(err, &permErr) {
+		app.response(w, http.StatusForbidden, map[string]any {"message": permErr.reason})
+		return
+	}
+
+	// Check the file size against the upload limit
+	st, err := app.store.Settings()
 	if err != nil {
 		app.serverError(w, err)
 		return
 	}
 
-	deviceData := deviceData{Pending: pdDevices, Saved: svDevices}
+	if st.MaxFileSize > 0 && form.Size > st.MaxFileSize {
+		app.response(w, http.StatusRequestEntityTooLarge, map[string]any {"message": "Upload is too large"})
+		return
+	}
 
-	// Render devices.html page
-	app.render(w, "devices", deviceData)
-}
+	// Create a session that lives for a day, so interrupted uploads can be continued later
+	// Only the salted hash of its secret is kept
+	secret := uuid.NewString()
+	salt, hash, err := hashSecret(secret)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
 
-// Handle device verification when the user clicks to verify the device on the devices page
-func (app *application) verifyDevicePost(w http.ResponseWriter, r *http.Request) {
-	var form verifyPostForm
-	
-	err := app.decodePostFormUrlEncoded(r, &form)
+	session := UploadSession{
+		Id: uuid.NewString(),
+		DeviceId: deviceId,
+		SecretSalt: salt,
+		SecretHash: hash,
+		FileName: form.Name,
+		Size: form.Size,
+		Offset: 0,
+		ExpiredAt: time.Now().Add(time.Hour * 24),
+	}
+
+	err = createUploadPart(app.config.uploadPartsDir(), session.Id)
 	if err != nil {
-		app.clientError(w, http.StatusBadRequest)
+		app.serverError(w, err)
 		return
 	}
-	
-	err = saveDevice(form.Id, form.Allow)
+
+	err = saveUploadSession(app.store, session)
 	if err != nil {
 		app.serverError(w, err)
 		return
 	}
 
+	app.response(w, http.StatusCreated, map[string]any {
+		"message": "Upload session created",
+		"id": session.Id,
+		"s": base64.StdEncoding.EncodeToString([]byte(secret)),
+		"offset": session.Offset,
+	})
+
+	app.infoLog.Printf("Created upload session %s for %s from %s\n", session.Id, session.FileName, r.RemoteAddr)
+}
+
+// Handle retrieving the current offset of an upload session, so the device knows where to continue
+func (app *application) uploadSessionStatus(w http.ResponseWriter, r *http.Request) {
+	session, ok := app.authorizeUploadSession(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
 	app.response(w, http.StatusOK, map[string]any {
-		"message": "Updated device lists successfully",
+		"offset": session.Offset,
+		"size": session.Size,
 	})
 }
 
-// Handle removing a specific device on the devices page
-func (app *application) removeDevice(w http.ResponseWriter, r *http.Request) {
-	var form removeDeviceForm
-	
-	err := app.decodePostFormUrlEncoded(r, &form)
+// Handle receiving a chunk of an upload session, the chunk must start at the current offset
+func (app *application) uploadChunk(w http.ResponseWriter, r *http.Request) {
+	session, ok := app.authorizeUploadSession(w, r)
+	if !ok {
+		return
+	}
+
+	// Hold the session until the chunk is saved, so two chunks at the same offset cannot both be written
+	session, unlock, ok := app.lockUploadSession(w, session.Id)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	// Validate the offset of the chunk
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
 	if err != nil {
 		app.clientError(w, http.StatusBadRequest)
 		return
 	}
 
-	// Remove the device from the list
-	err = removeDevice(form.Id)
-	if err != nil {
-		app.serverError(w, err)
+	// If the offset is not matched, then tell the device where to continue
+	if offset != session.Offset {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+		app.response(w, http.StatusConflict, map[string]any {
+			"message": "Offset mismatch",
+			"offset": session.Offset,
+		})
 		return
 	}
-	
-	app.response(w, http.StatusOK, map[string]any {
-		"message": "Updated device lists successfully",
-	})
-}
 
+	// Write the chunk, and keep whatever was received even if the connection dropped
+	extendDeadlines(w)
+	device, _ := getSavedDevice(app.store, session.DeviceId)
+	tr := app.transfers.start(w, session.Id, session.DeviceId, device.Name, session.FileName, session.Size, session.Offset)
+	defer app.transfers.pause(tr)
 
-/* --- SETTINGS --- */
+	n, chunkErr := writeUploadChunk(app.config.uploadPartsDir(), session, app.transfers.reader(tr, r.Body))
+	session.Offset += n
 
-// Handle retrieving and displaying the HTTP server settings to the settings page
-func (app *application) settings(w http.ResponseWriter, r *http.Request) {
-	var st settingsData
-	
-	// Get settings info
-	err := readJSONFile(&st, SETTINGS_FILE_PATH)
+	// A cancelled transfer ends its upload session, so the device cannot continue it
+	if chunkErr != nil && tr.cancelled.Load() {
+		app.transfers.finish(tr)
+		err = deleteUploadSession(app.store, app.config.uploadPartsDir(), session.Id)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		app.response(w, http.StatusConflict, map[string]any {"message": "The transfer was cancelled on the PC"})
+		return
+	}
+
+	err = saveUploadSession(app.store, session)
 	if err != nil {
 		app.serverError(w, err)
 		return
 	}
 
-	// Construct data to parse to the template
-	QRCodeData := app.hostInfo.HostName + " " + app.hostInfo.IPAddr.String()
-	data := &settingsForm{
-		QRCodeData: QRCodeData,
-		Dst: st.Dst,
+	if chunkErr != nil {
+		if errors.Is(chunkErr, ErrUploadTooLarge) {
+			app.response(w, http.StatusRequestEntityTooLarge, map[string]any {
+				"message": "Chunk exceeds the declared file size",
+				"offset": session.Offset,
+			})
+			return
+		}
+		app.serverError(w, chunkErr)
+		return
 	}
 
-	// Render settings.html page
-	app.render(w, "settings", data)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	app.response(w, http.StatusOK, map[string]any {
+		"offset": session.Offset,
+		"size": session.Size,
+	})
 }
 
-// Handle updating the HTTP server settings
-func (app *application) settingsPost(w http.ResponseWriter, r *http.Request) {
-	var form settingsPostForm
-	
-	err := app.decodePostFormUrlEncoded(r, &form)
-	if err != nil {
-		app.clientError(w, http.StatusBadRequest)
+// Handle finalizing an upload session by moving the completed file into the destination folder
+func (app *application) finalizeUploadSession(w http.ResponseWriter, r *http.Request) {
+	session, ok := app.authorizeUploadSession(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.allowDevice(w, session.DeviceId) {
+		return
+	}
+
+	// Hold the session until the file is moved, so it cannot be finalized twice or receive a chunk meanwhile
+	session, unlock, ok := app.lockUploadSession(w, session.Id)
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	// Reject the request if the file is not completed yet
+	if session.Offset != session.Size {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+		app.response(w, http.StatusConflict, map[string]any {
+			"message": "Upload is not completed",
+			"offset": session.Offset,
+		})
+		return
+	}
+
+	// Get the destination folder path to save
+	st, err := app.store.Settings()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Move the file to the folder chosen by the routing rules unless it is skipped by the collision policy
+	name := sanitizeFileName(session.FileName)
+
+	// The permissions of the device may have changed since the session was created
+	device, err := getSavedDevice(app.store, session.DeviceId)
+	if errors.Is(err, ErrDeviceNotFound) {
+		app.response(w, http.StatusForbidden, map[string]any {"message": "Please register your device again"})
+		return
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = device.Perms().checkUpload(name, session.Size)
+	var permErr permissionError
+	if errors.As(err, &permErr) {
+		app.transfers.remove(session.Id)
+		err = deleteUploadSession(app.store, app.config.uploadPartsDir(), session.Id)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		app.response(w, http.StatusForbidden, map[string]any {"message": permErr.reason})
+		return
+	}
+
+	file := routedFile{DeviceId: session.DeviceId, DeviceName: device.Name, Name: name, MimeType: fileMimeType(name, ""), Size: session.Size}
+	dir := routeFile(st, file, time.Now())
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	dst, err := reserveFilePath(dir, name, st.OnConflict)
+	if errors.Is(err, ErrFileSkipped) {
+		app.transfers.remove(session.Id)
+		err = deleteUploadSession(app.store, app.config.uploadPartsDir(), session.Id)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+
+		app.recordFiles(session.DeviceId, nil, []string{name})
+
+		app.response(w, http.StatusOK, map[string]any {
+			"message": "File already exists, skipped",
+			"skipped": []string{name},
+		})
+		return
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = moveFile(uploadPartPath(app.config.uploadPartsDir(), session.Id), dst)
+	if err != nil {
+		releaseFilePath(dst, st.OnConflict)
+		app.serverError(w, err)
+		return
+	}
+
+	app.transfers.remove(session.Id)
+	err = deleteUploadSession(app.store, app.config.uploadPartsDir(), session.Id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.recordFiles(session.DeviceId, []savedFile{{Name: name, Path: dst, Size: session.Size}}, nil)
+
+	// Open the folder of the file
+	app.revealFolder(dir)
+
+	app.response(w, http.StatusOK, map[string]any {
+		"message": "Received all content successfully",
+	})
+
+	app.recordActivity(session.DeviceId, r, true, session.Size)
+	app.events.publish(EVENT_UPLOAD, map[string]any {
+		"id": session.DeviceId,
+		"files": 1,
+		"skipped": 0,
+		"bytes": session.Size,
+	})
+	app.infoLog.Printf("Uploaded %s from %s\n", session.FileName, r.RemoteAddr)
+}
+
+
+/* --- DEVICES --- */
+
+// Handle displaying all devices on the devices page
+func (app *application) getDevices(w http.ResponseWriter, r *http.Request) {
+	// Get pending devices
+	pdDevices, err := app.store.PendingDevices()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Get saved devices
+	svDevices, err := app.store.Devices()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	csrfToken, err := app.csrfToken(w, r)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Get the audit log, latest first
+	audit, err := app.store.AuditLog()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	slices.Reverse(audit)
+
+	deviceData := deviceData{CSRFToken: csrfToken, Pending: pdDevices, Saved: svDevices, Blocked: app.limiter.blocked(), Audit: audit}
+
+	// Render devices.html page
+	app.render(w, "devices", deviceData)
+}
+
+// Handle device verification when the user clicks to verify the device on the devices page
+func (app *application) verifyDevicePost(w http.ResponseWriter, r *http.Request) {
+	var form verifyPostForm
+	
+	err := app.decodePostFormUrlEncoded(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+	
+	err = saveDevice(app.store, form.Id, form.Allow, remoteIP(r))
+	if err != nil {
+		if errors.Is(err, ErrDeviceNotFound) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.response(w, http.StatusOK, map[string]any {
+		"message": "Updated device lists successfully",
+	})
+}
+
+// Handle removing a specific device on the devices page
+func (app *application) removeDevice(w http.ResponseWriter, r *http.Request) {
+	var form removeDeviceForm
+	
+	err := app.decodePostFormUrlEncoded(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// Remove the device from the list
+	err = removeDevice(app.store, form.Id, remoteIP(r))
+	if err != nil {
+		if errors.Is(err, ErrDeviceNotFound) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+	
+	app.response(w, http.StatusOK, map[string]any {
+		"message": "Updated device lists successfully",
+	})
+}
+
+// Handle lifting the lockout of a client when the user clicks unblock on the devices page
+func (app *application) unblockClient(w http.ResponseWriter, r *http.Request) {
+	var form unblockForm
+
+	err := app.decodePostFormUrlEncoded(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if !app.limiter.unblock(form.Key) {
+		app.notFound(w)
+		return
+	}
+
+	app.response(w, http.StatusOK, map[string]any {
+		"message": "Unblocked the client successfully",
+	})
+}
+
+// Handle updating what a saved device is allowed to send
+func (app *application) devicePermissionsPost(w http.ResponseWriter, r *http.Request) {
+	var form permissionsPostForm
+
+	err := app.decodePostFormUrlEncoded(r, &form)
+	if err != nil || form.MaxSizeMB < 0 || form.MaxSizeMB > MAX_SIZE_MB {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	perms := DevicePermissions{
+		Files: form.Files,
+		URL: form.URL,
+		Text: form.Text,
+		MaxSize: form.MaxSizeMB << 20,
+		Extensions: splitList(form.Extensions, normalizeExtension),
+	}
+
+	err = setDevicePermissions(app.store, form.Id, perms)
+	if err != nil {
+		if errors.Is(err, ErrDeviceNotFound) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.response(w, http.StatusOK, map[string]any {
+		"message": "Updated device permissions successfully",
+	})
+}
+
+
+/* --- URLS --- */
+
+// Handle displaying the URLs that wait to be opened
+func (app *application) getPendingURLs(w http.ResponseWriter, r *http.Request) {
+	csrfToken, err := app.csrfToken(w, r)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, "urls", &pendingURLsData{CSRFToken: csrfToken, URLs: app.pendingURLs.list()})
+}
+
+// Handle opening or dismissing a URL that waits to be opened
+func (app *application) pendingURLPost(w http.ResponseWriter, r *http.Request) {
+	var form pendingURLForm
+
+	err := app.decodePostFormUrlEncoded(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	pending, ok := app.pendingURLs.take(form.Id)
+	if !ok {
+		app.notFound(w)
+		return
+	}
+
+	if !form.Open {
+		app.recordURL(pending.DeviceId, pending.URL, URL_DISMISSED)
+		app.response(w, http.StatusOK, map[string]any {"message": "Dismissed the URL"})
+		return
+	}
+
+	status := app.openURL(pending.DeviceId, pending.URL, false)
+	if status == URL_FAILED {
+		app.response(w, http.StatusInternalServerError, map[string]any {"message": "Could not open the URL"})
+		return
+	}
+
+	app.response(w, http.StatusOK, map[string]any {"message": "Opened the URL"})
+}
+
+
+/* --- ROUTING RULES --- */
+
+// Handle adding a rule that sorts the received files into folders
+func (app *application) addRule(w http.ResponseWriter, r *http.Request) {
+	var form ruleForm
+
+	err := app.decodePostFormUrlEncoded(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if form.MinSizeMB > MAX_SIZE_MB || form.MaxSizeMB > MAX_SIZE_MB {
+		app.response(w, http.StatusBadRequest, map[string]any {"message": "the size range is not valid"})
+		return
+	}
+
+	rule := RoutingRule{
+		Device: strings.TrimSpace(form.Device),
+		Extensions: splitList(form.Extensions, normalizeExtension),
+		MimeType: strings.ToLower(strings.TrimSpace(form.MimeType)),
+		Pattern: strings.TrimSpace(form.Pattern),
+		MinSize: form.MinSizeMB << 20,
+		MaxSize: form.MaxSizeMB << 20,
+		Path: strings.TrimSpace(form.Path),
+	}
+
+	err = addRoutingRule(app.store, rule)
+	if err != nil {
+		var ruleErr ruleError
+		if errors.As(err, &ruleErr) {
+			app.response(w, http.StatusBadRequest, map[string]any {"message": ruleErr.reason})
+			return
+		}
+		app.serverError(w, err)
+		return
+	}
+
+	app.response(w, http.StatusOK, map[string]any {
+		"message": "Added the rule successfully",
+	})
+}
+
+// Handle removing a routing rule
+func (app *application) removeRule(w http.ResponseWriter, r *http.Request) {
+	var form ruleIdForm
+
+	err := app.decodePostFormUrlEncoded(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = removeRoutingRule(app.store, form.Id)
+	if err != nil {
+		if errors.Is(err, ErrRuleNotFound) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.response(w, http.StatusOK, map[string]any {
+		"message": "Removed the rule successfully",
+	})
+}
+
+// Handle moving a routing rule up, so it is checked before the previous one
+func (app *application) moveRuleUp(w http.ResponseWriter, r *http.Request) {
+	var form ruleIdForm
+
+	err := app.decodePostFormUrlEncoded(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	err = moveRoutingRuleUp(app.store, form.Id)
+	if err != nil {
+		if errors.Is(err, ErrRuleNotFound) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, err)
+		}
+		return
+	}
+
+	app.response(w, http.StatusOK, map[string]any {
+		"message": "Moved the rule successfully",
+	})
+}
+
+
+/* --- HISTORY --- */
+
+// Handle displaying the transfer history, it can be searched with 'q' and filtered with 'kind'
+func (app *application) getHistory(w http.ResponseWriter, r *http.Request) {
+	csrfToken, err := app.csrfToken(w, r)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	history, err := app.store.History()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	query := r.URL.Query().Get("q")
+	kind := r.URL.Query().Get("kind")
+	if !slices.Contains([]string{TRANSFER_URL, TRANSFER_TEXT, TRANSFER_FILE}, kind) {
+		kind = ""
+	}
+
+	app.render(w, "history", &historyData{
+		CSRFToken: csrfToken,
+		Query: query,
+		Kind: kind,
+		Records: searchHistory(history, query, kind),
+	})
+}
+
+// Handle showing a received file in its folder
+func (app *application) revealHistoryFile(w http.ResponseWriter, r *http.Request) {
+	record, ok := app.historyRecord(w, r, TRANSFER_FILE)
+	if !ok {
+		return
+	}
+
+	if record.Path == "" {
+		app.response(w, http.StatusNotFound, map[string]any {"message": "The file was not saved"})
+		return
+	}
+	if _, err := os.Stat(record.Path); err != nil {
+		app.response(w, http.StatusNotFound, map[string]any {"message": "The file was moved or removed"})
+		return
+	}
+
+	err := app.platform.RevealFile(record.Path)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.response(w, http.StatusOK, map[string]any {"message": "Showed the file"})
+}
+
+// Handle copying a received text to the clipboard again
+func (app *application) copyHistoryText(w http.ResponseWriter, r *http.Request) {
+	record, ok := app.historyRecord(w, r, TRANSFER_TEXT)
+	if !ok {
+		return
+	}
+
+	err := app.platform.SetClipboard(record.Value)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	message := "Copied the text to the clipboard"
+	if record.Truncated {
+		message = "Copied the start of the text to the clipboard, the rest is not kept in the history"
+	}
+
+	app.response(w, http.StatusOK, map[string]any {"message": message})
+}
+
+// Handle opening a received URL again like a new one, it must still pass the URL policy and is asked first
+// when URLs are confirmed
+func (app *application) openHistoryURL(w http.ResponseWriter, r *http.Request) {
+	record, ok := app.historyRecord(w, r, TRANSFER_URL)
+	if !ok {
+		return
+	}
+
+	st, err := app.store.Settings()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	safeURL, err := checkURL(record.Value, st)
+	var urlErr urlError
+	if errors.As(err, &urlErr) {
+		app.recordURL(record.DeviceId, record.Value, URL_BLOCKED)
+		app.response(w, http.StatusForbidden, map[string]any {"message": urlErr.reason})
+		return
+	}
+
+	switch app.openURL(record.DeviceId, safeURL, st.URLConfirm) {
+	case URL_PENDING:
+		app.response(w, http.StatusAccepted, map[string]any {"message": "The URL is waiting to be opened on the URLs page"})
+	case URL_FAILED:
+		app.response(w, http.StatusInternalServerError, map[string]any {"message": "Could not open the URL"})
+	default:
+		app.response(w, http.StatusOK, map[string]any {"message": "Opened the URL"})
+	}
+}
+
+
+/* --- PAIRING --- */
+
+// Handle minting a new pairing code for the QR code on the settings page, before the shown one expires
+func (app *application) newPairingCode(w http.ResponseWriter, r *http.Request) {
+	QRCodeData, err := app.pairingQRCode()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.response(w, http.StatusOK, map[string]any {
+		"qr": QRCodeData,
+		"ttl": int(PAIRING_CODE_TTL.Seconds()),
+	})
+}
+
+
+/* --- EVENTS --- */
+
+// Handle streaming the server events to an open page with Server-Sent Events
+func (app *application) streamEvents(w http.ResponseWriter, r *http.Request) {
+	// A page lists the event types it shows, eg. /events?types=device_pending,device_expired
+	types := splitList(r.URL.Query().Get("types"), func(t string) string { return strings.TrimSpace(t) })
+	events, unsubscribe := app.events.subscribe(types)
+	defer unsubscribe()
+
+	// The stream stays open, so it must not be cut off by the server timeouts
+	extendDeadlines(w)
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	keepalive := time.NewTicker(EVENTS_KEEPALIVE)
+	defer keepalive.Stop()
+
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			err = writeEvent(w, event)
+		case <-keepalive.C:
+			_, err = w.Write([]byte(": keepalive\n\n"))
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+
+/* --- TRANSFERS --- */
+
+// Handle listing the progress of the uploads that are being received
+func (app *application) getTransfers(w http.ResponseWriter, r *http.Request) {
+	app.response(w, http.StatusOK, map[string]any {
+		"transfers": app.transfers.list(),
+	})
+}
+
+// Handle cancelling an upload that is being received
+func (app *application) cancelTransfer(w http.ResponseWriter, r *http.Request) {
+	var form cancelTransferForm
+
+	err := app.decodePostFormUrlEncoded(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	if !app.transfers.cancel(form.Id) {
+		app.notFound(w)
+		return
+	}
+
+	// A cancelled transfer ends its upload session, so the device cannot continue it
+	_, err = getUploadSession(app.store, form.Id)
+	if err == nil {
+		err = deleteUploadSession(app.store, app.config.uploadPartsDir(), form.Id)
+	}
+	if err != nil && !errors.Is(err, ErrUploadSessionNotFound) {
+		app.serverError(w, err)
+		return
+	}
+
+	app.infoLog.Printf("Cancelled the transfer %s\n", form.Id)
+	app.response(w, http.StatusOK, map[string]any {
+		"message": "Cancelled the transfer",
+	})
+}
+
+
+/* --- LOGIN --- */
+
+// Handle displaying the login page when the admin password is set
+func (app *application) login(w http.ResponseWriter, r *http.Request) {
+	st, err := app.store.Settings()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if st.AdminPasswordHash == "" {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	csrfToken, err := app.csrfToken(w, r)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, "login", &loginData{CSRFToken: csrfToken})
+}
+
+// Handle logging in to the local UI with the admin password
+func (app *application) loginPost(w http.ResponseWriter, r *http.Request) {
+	var form loginPostForm
+
+	err := app.decodePostFormUrlEncoded(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	st, err := app.store.Settings()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if st.AdminPasswordHash == "" {
+		app.notFound(w)
+		return
+	}
+
+	id, err := app.adminSessions.login(st.AdminPasswordHash, form.Password)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if id == "" {
+		app.authFailed(r)
+		app.errorLog.Println("Failed login to the local UI from", r.RemoteAddr)
+		app.response(w, http.StatusUnauthorized, map[string]any {"message": "Wrong password"})
+		return
+	}
+
+	app.setCookie(w, ADMIN_SESSION_COOKIE, id, int(ADMIN_SESSION_TTL.Seconds()))
+	app.response(w, http.StatusOK, map[string]any {"message": "Logged in successfully"})
+}
+
+// Handle logging out of the local UI
+func (app *application) logout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(ADMIN_SESSION_COOKIE); err == nil {
+		app.adminSessions.logout(cookie.Value)
+	}
+
+	app.setCookie(w, ADMIN_SESSION_COOKIE, "", -1)
+	app.response(w, http.StatusOK, map[string]any {"message": "Logged out successfully"})
+}
+
+
+/* --- SETTINGS --- */
+
+// Handle retrieving and displaying the HTTP server settings to the settings page
+func (app *application) settings(w http.ResponseWriter, r *http.Request) {
+	// Get settings info
+	st, err := app.store.Settings()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Mint a one-time pairing code for a device that scans the QR code
+	QRCodeData, err := app.pairingQRCode()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	csrfToken, err := app.csrfToken(w, r)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// Construct data to parse to the template
+	data := &settingsForm{
+		CSRFToken: csrfToken,
+		HasPassword: st.AdminPasswordHash != "",
+		QRCodeData: QRCodeData,
+		PairingTTL: int(PAIRING_CODE_TTL.Seconds()),
+		Dst: st.Dst,
+		OnConflict: conflictPolicy(st.OnConflict),
+		TokenMode: st.TokenMode,
+		SessionMinutes: st.SessionMinutes,
+		SessionUploads: st.SessionUploads,
+		URLSchemes: strings.Join(urlSchemes(st), ", "),
+		URLAllowDomains: strings.Join(st.URLAllowDomains, ", "),
+		URLDenyDomains: strings.Join(st.URLDenyDomains, ", "),
+		URLConfirm: st.URLConfirm,
+		DeviceExpiryDays: st.DeviceExpiryDays,
+		DeviceInactiveDays: st.DeviceInactiveDays,
+		MaxFileSizeMB: sizeInMB(st.MaxFileSize),
+		MaxRequestSizeMB: sizeInMB(st.MaxRequestSize),
+		Rules: st.Rules,
+	}
+	if data.TokenMode != TOKEN_MODE_SESSION {
+		data.TokenMode = TOKEN_MODE_SINGLE
+	}
+	if data.SessionMinutes <= 0 {
+		data.SessionMinutes = DEFAULT_SESSION_MINUTES
+	}
+
+	// Render settings.html page
+	app.render(w, "settings", data)
+}
+
+// Handle updating the HTTP server settings
+func (app *application) settingsPost(w http.ResponseWriter, r *http.Request) {
+	var form settingsPostForm
+	
+	err := app.decodePostFormUrlEncoded(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
 		return
 	}
 
@@ -291,15 +1384,77 @@ func (app *application) settingsPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// If ok, then change the saved folder destination
-	err = setDstPath(form.Dst)
+	// Validate the collision policy
+	if !isValidConflictPolicy(form.OnConflict) {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// Validate the token mode
+	if (form.TokenMode != TOKEN_MODE_SINGLE && form.TokenMode != TOKEN_MODE_SESSION) || form.SessionMinutes <= 0 || form.SessionUploads < 0 {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// Validate the device expiry policy
+	if form.DeviceExpiryDays < 0 || form.DeviceInactiveDays < 0 {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// Validate the upload limits
+	if form.MaxFileSizeMB < 0 || form.MaxFileSizeMB > MAX_SIZE_MB || form.MaxRequestSizeMB < 0 || form.MaxRequestSizeMB > MAX_SIZE_MB {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// Validate the URL policy, at least one scheme is required
+	schemes := splitList(form.URLSchemes, normalizeScheme)
+	if len(schemes) == 0 {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// If ok, then change the saved folder destination, the collision policy, the token mode, the URL policy,
+	// the device expiry policy and the upload limits
+	err = setDstPath(app.store, form.Dst)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = setConflictPolicy(app.store, form.OnConflict)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = setTokenMode(app.store, form.TokenMode, form.SessionMinutes, form.SessionUploads)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = setURLPolicy(app.store, schemes, splitList(form.URLAllowDomains, normalizeDomain), splitList(form.URLDenyDomains, normalizeDomain), form.URLConfirm)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = setDeviceExpiry(app.store, form.DeviceExpiryDays, form.DeviceInactiveDays)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = setUploadLimits(app.store, form.MaxFileSizeMB << 20, form.MaxRequestSizeMB << 20)
 	if err != nil {
 		app.serverError(w, err)
 		return
 	}
 
 	app.response(w, http.StatusOK, map[string]any {
-		"message": "Saved new destination successfully",
+		"message": "Saved new settings successfully",
 	})
 }
 
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-playground/form/v4"
+)
+
+// [tests] Create an application with an in-memory store and a platform that records its actions
+func newTestApp(st settingsData) (*application, *recordingPlatform) {
+	platform := &recordingPlatform{}
+	events := newEventBroker()
+
+	app := &application{
+		infoLog: log.New(io.Discard, "", 0),
+		errorLog: log.New(io.Discard, "", 0),
+		formDecoder: form.NewDecoder(),
+		pairingCodes: newPairingCodes(),
+		challenges: newConnectChallenges(),
+		pendingURLs: newPendingURLs(),
+		limiter: newRateLimiter(),
+		adminSessions: newAdminSessions(),
+		events: events,
+		transfers: newTransfers(events),
+		store: newMemoryStore(st),
+		platform: platform,
+	}
+
+	return app, platform
+}
+
+// [tests] Post a form to a handler and get the response
+func postForm(handler http.HandlerFunc, values url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(values.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	return w
+}
+
+// formPart is a value or a file of a multipart form
+type formPart struct {
+	name			string
+	fileName	string	// empty for a value
+	content		string
+}
+
+// [tests] Encode the parts of a multipart form, and get the body with its content type
+func multipartForm(t *testing.T, parts ...formPart) (*bytes.Buffer, string) {
+	t.Helper()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, part := range parts {
+		var w io.Writer
+		var err error
+		if part.fileName == "" {
+			w, err = mw.CreateFormField(part.name)
+		} else {
+			w, err = mw.CreateFormFile(part.name, part.fileName)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, part.content)
+	}
+	mw.Close()
+
+	return &body, mw.FormDataContentType()
+}
+
+// [tests] Get a multipart reader of the parts of a form
+func multipartReader(t *testing.T, parts ...formPart) *multipart.Reader {
+	t.Helper()
+
+	body, contentType := multipartForm(t, parts...)
+	r := httptest.NewRequest(http.MethodPost, "/", body)
+	r.Header.Set("Content-Type", contentType)
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return mr
+}
+
+// [tests] Save the device dev1 with the given permissions and a token with the secret "secret"
+func saveTestDevice(t *testing.T, app *application, perms *DevicePermissions) {
+	t.Helper()
+
+	err := app.store.UpdateDevices(func(list *DeviceList) error {
+		list.Devices = append(list.Devices, DeviceInfo{Name: "phone", Identifier: "dev1", Permissions: perms})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := newToken("dev1", "secret", time.Now().Add(time.Hour), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = saveToken(app.store, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// [tests] Send a multipart form to /upload as the device dev1
+func uploadRequest(t *testing.T, app *application, parts ...formPart) *httptest.ResponseRecorder {
+	t.Helper()
+
+	body, contentType := multipartForm(t, parts...)
+	r := httptest.NewRequest(http.MethodPost, "/upload", body)
+	r.Header.Set("Content-Type", contentType)
+	r.SetBasicAuth("dev1", "secret")
+
+	w := httptest.NewRecorder()
+	app.upload(w, r)
+
+	return w
+}
+
+func TestUploadPlatform(t *testing.T) {
+	dst := t.TempDir()
+	app, platform := newTestApp(settingsData{Dst: dst, OnConflict: CONFLICT_RENAME})
+	saveTestDevice(t, app, nil)
+
+	w := uploadRequest(t, app,
+		formPart{name: "url", content: "https://example.com"},
+		formPart{name: "text", content: "hello"},
+		formPart{name: "file", fileName: "a.txt", content: "a"},
+	)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	// Every action on this PC goes through the platform
+	want := []string{"OpenURL", "SetClipboard", "Notify", "RevealFolder"}
+	got := []string{}
+	for _, call := range platform.Calls {
+		got = append(got, call.Action)
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got platform calls %+v, want %v", platform.Calls, want)
+	}
+	if platform.Calls[0].Args[0] != "https://example.com" || platform.Calls[1].Args[0] != "hello" || platform.Calls[3].Args[0] != dst {
+		t.Errorf("got platform calls %+v, want the URL opened, the text copied and %s revealed", platform.Calls, dst)
+	}
+
+	// A failing clipboard fails the upload
+	platform.Err = errors.New("no clipboard")
+	w = uploadRequest(t, app, formPart{name: "text", content: "hello"})
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+}
@@ -4,20 +4,20 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"html/template"
 	"io"
+	"mime/multipart"
 	"net"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"runtime/debug"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/atotto/clipboard"
 	"github.com/go-playground/form/v4"
 	"github.com/grandcat/zeroconf"
+	"github.com/julienschmidt/httprouter"
 )
 
 /* --- SERVER --- */
@@ -71,26 +71,30 @@ func (app *application) decodePostFormUrlEncoded(r *http.Request, dst any) error
 
 // [helpers] Display a HTML page with a specific data
 func (app *application) render(w http.ResponseWriter, page string, data any) {	
-	page = fmt.Sprintf("./ui/html/%s.html", page)
-	files := []string{
-		page,
-	}
+	ts, ok := app.templateCache[page]
 
-	ts, err := template.ParseFiles(files...)
-	if err != nil {
-		app.serverError(w, err)
+	// Parse the page on every request when the UI is loaded from disk, so changes show up without restarting
+	if app.config.uiDir != "" {
+		var err error
+		ts, err = parseTemplate(app.uiFS, page)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	} else if !ok {
+		app.serverError(w, fmt.Errorf("the template %s does not exist", page))
 		return
 	}
 
-	err = ts.ExecuteTemplate(w, "base", data)
+	err := ts.ExecuteTemplate(w, "base", data)
 	if err != nil {
 		app.serverError(w, err)
 	}
 }
 
-// [helpers] Advertise the mDNS service on port 9876
+// [helpers] Advertise the mDNS service on the configured port
 func (app *application) advertiseMDNSService() (*zeroconf.Server, error) {
-	port := 9876
+	port := app.config.mDNSPort
 
 	ipAddr := strings.Join(strings.Split(app.hostInfo.IPAddr.String(), "."), "--")
 	instance := app.hostInfo.HostName + "__" + ipAddr
@@ -138,6 +142,64 @@ func (app *application) refreshMDNSService() error {
 	return nil
 }
 
+// [helpers] Periodically remove expired tokens and upload sessions, instead of waiting for the next request to do it
+func (app *application) sweepExpired(interval time.Duration) {
+	for {
+		<-time.After(interval)
+
+		tokens, err := purgeExpiredTokens(app.store)
+		if err != nil {
+			app.errorLog.Println("Failed to purge expired tokens:", err)
+		}
+
+		sessions, err := purgeExpiredUploadSessions(app.store, app.config.uploadPartsDir())
+		if err != nil {
+			app.errorLog.Println("Failed to purge expired upload sessions:", err)
+		}
+		for _, id := range sessions {
+			app.transfers.remove(id)
+		}
+
+		pending, err := purgeExpiredPendingDevices(app.store)
+		if err != nil {
+			app.errorLog.Println("Failed to purge expired pending devices:", err)
+		}
+
+		if tokens > 0 || len(sessions) > 0 || pending > 0 {
+			app.infoLog.Printf("Purged %d expired token(s), %d expired upload session(s) and %d expired pending device(s)\n", tokens, len(sessions), pending)
+		}
+
+		app.limiter.sweep()
+
+		_, err = app.expireDevices()
+		if err != nil {
+			app.errorLog.Println("Failed to expire devices:", err)
+		}
+	}
+}
+
+// [helpers] Get the IP address a request was sent from
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return ip
+}
+
+// [helpers] Split a comma-separated list and normalize its items, empty items are dropped
+func splitList(list string, normalize func(string) string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = normalize(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
 // [helpers] Open a log file located on a given path
 func openLogFile(path string) (*os.File, error) {
 	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
@@ -178,78 +240,309 @@ func checkDirValid(path string) error {
 	return nil
 }
 
-// [helpers] Set the server's uploaded path
-func setDstPath(newPath string) error {
-	// read saved devices from json file
-	var st settingsData
-	
-	err := readJSONFile(&st, SETTINGS_FILE_PATH)
-	if err != nil {
-		return err
-	}
+// [helpers] Set the server's collision policy for uploaded files with existing names
+func setConflictPolicy(store Store, policy string) error {
+	return store.UpdateSettings(func(st *settingsData) error {
+		st.OnConflict = policy
+		return nil
+	})
+}
+
+// [helpers] Set how many uploads a connection allows
+func setTokenMode(store Store, mode string, sessionMinutes, sessionUploads int) error {
+	return store.UpdateSettings(func(st *settingsData) error {
+		st.TokenMode = mode
+		st.SessionMinutes = sessionMinutes
+		st.SessionUploads = sessionUploads
+		return nil
+	})
+}
 
-	st.Dst = newPath
+// [helpers] Set after how many days devices expire and have to be approved again, 0 turns a limit off
+func setDeviceExpiry(store Store, expiryDays, inactiveDays int) error {
+	return store.UpdateSettings(func(st *settingsData) error {
+		st.DeviceExpiryDays = expiryDays
+		st.DeviceInactiveDays = inactiveDays
+		return nil
+	})
+}
 
-	// save the json file back
-	err = writeJSONFile(st, SETTINGS_FILE_PATH)
-	if err != nil {
-		return err
-	}
+// [helpers] Set the largest file and the largest upload request in bytes, 0 means no limit
+func setUploadLimits(store Store, maxFileSize, maxRequestSize int64) error {
+	return store.UpdateSettings(func(st *settingsData) error {
+		st.MaxFileSize = maxFileSize
+		st.MaxRequestSize = maxRequestSize
+		return nil
+	})
+}
 
-	return nil
+// [helpers] Get a size in bytes in MB for the settings page, rounded up so a limit below 1MB is not shown as no limit
+func sizeInMB(bytes int64) int64 {
+	return bytes >> 20 + min(bytes & (1 << 20 - 1), 1)
+}
+
+// [helpers] Set the server's uploaded path
+func setDstPath(store Store, newPath string) error {
+	return store.UpdateSettings(func(st *settingsData) error {
+		st.Dst = newPath
+		return nil
+	})
 }
 
 
 /* --- HANDLE UPLOADED DATA --- */
 
-// [helpers] Save uploaded files into a given folder
-func saveFiles(r *http.Request, path string) error {
-	for _, fs := range r.MultipartForm.File {
-		for _, fh := range fs {
-			f, err := fh.Open()
-			if err != nil {
-				return err
+// [helpers] Stream the parts of a multipart request, files are written directly into the folder chosen by the routing rules
+// and the other form values are returned along with the saved files. The name of the file being
+// received is shown on the transfer 'tr' if it is not nil. If any part fails, eg. it is not allowed for the device,
+// the files saved before it are removed, so the device can send the whole request again
+func saveFiles(mr *multipart.Reader, st settingsData, device DeviceInfo, tr *transfer) (content uploadedContent, err error) {
+	content.Values = map[string]string{}
+	content.Skipped = []string{}
+	perms := device.Perms()
+	now := time.Now()
+
+	defer func() {
+		if err != nil {
+			for _, file := range content.Saved {
+				os.Remove(file.Path)
 			}
-			defer f.Close()
+			content.Saved = nil
+		}
+	}()
 
-			dstF, err := os.Create(filepath.Join(path, fh.Filename))
-			if err != nil {
-				return err
-			}
-			defer dstF.Close()
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return content, err
+		}
 
-			_, err = io.Copy(dstF, f)
+		// Keep form values in memory, up to 1MB each. Empty values are ignored, so they need no permission
+		if part.FileName() == "" {
+			val, err := io.ReadAll(io.LimitReader(part, 1 << 20))
 			if err != nil {
-				return err
+				return content, err
+			}
+			if len(val) > 0 {
+				err = perms.checkValue(part.FormName())
+				if err != nil {
+					return content, err
+				}
+				content.Values[part.FormName()] = string(val)
+				content.Bytes += int64(len(val))
 			}
+			continue
 		}
+
+		name := sanitizeFileName(part.FileName())
+		tr.setFileName(name)
+		err = perms.checkFile(name)
+		if err != nil {
+			return content, err
+		}
+
+		// The device limit is reported as a permission error, so it is checked after the file is received
+		maxSize, isDeviceLimit := st.MaxFileSize, false
+		if perms.MaxSize > 0 && (maxSize == 0 || perms.MaxSize < maxSize) {
+			maxSize, isDeviceLimit = perms.MaxSize, true
+		}
+		file := routedFile{
+			DeviceId: device.Identifier,
+			DeviceName: device.Name,
+			Name: name,
+			MimeType: fileMimeType(name, part.Header.Get("Content-Type")),
+		}
+		route := func(size int64) string {
+			file.Size = size
+			return routeFile(st, file, now)
+		}
+
+		received := &countingReader{r: part}
+		dst, err := saveFile(received, st.Dst, name, st.OnConflict, maxSize, route)
+		content.Bytes += received.n
+		if errors.Is(err, ErrFileTooLarge) && isDeviceLimit {
+			return content, errTooLargeForDevice
+		}
+		if errors.Is(err, ErrFileSkipped) {
+			content.Skipped = append(content.Skipped, name)
+			continue
+		}
+		if err != nil {
+			return content, err
+		}
+		content.Saved = append(content.Saved, savedFile{Name: name, Path: dst, Size: received.n})
 	}
 
-	return nil
+	return content, nil
+}
+
+// [helpers] Write a file named 'name' from a given reader into the folder returned by 'route' based on the collision policy.
+// The data is written to a temporary file in 'tmpDir' first, so nothing is left behind if it is larger than 'maxSize'
+// or the copy fails, and the folder can depend on its size. 'route' is given -1 before the size is known
+func saveFile(src io.Reader, tmpDir, name, policy string, maxSize int64, route func(size int64) string) (string, error) {
+	// Do not receive the file at all if it will be skipped
+	if dir := route(-1); dir != "" && conflictPolicy(policy) == CONFLICT_SKIP {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			io.Copy(io.Discard, src)
+			return "", ErrFileSkipped
+		}
+	}
+
+	f, err := os.CreateTemp(tmpDir, ".iwin-*.part")
+	if err != nil {
+		return "", err
+	}
+	tmp := f.Name()
+
+	// Read one more byte than allowed to detect files that exceed the limit
+	if maxSize > 0 {
+		src = io.LimitReader(src, maxSize+1)
+	}
+
+	n, err := io.Copy(f, src)
+	if err == nil && maxSize > 0 && n > maxSize {
+		err = ErrFileTooLarge
+	}
+
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	// Move the completed file to its final name, the folder of a rule may not exist yet
+	dir := route(n)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	dst, err := reserveFilePath(dir, name, policy)
+	if err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	err = moveFile(tmp, dst)
+	if err != nil {
+		os.Remove(tmp)
+		releaseFilePath(dst, policy)
+		return "", err
+	}
+
+	return dst, nil
 }
 
-// [helpers] Open a given folder
-func openFolder(path string) {
-	cmd := exec.Command(`explorer`, path)
-	cmd.Run()
+// [helpers] Remove the read and write deadlines of a request, so large uploads are not cut off by the server timeouts
+func extendDeadlines(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
 }
 
-// [helpers] Open a given URL on the browser
-func openURL(url string) {
-	args := []string{"/c", "start", url}
-	exec.Command("cmd", args...).Run()
+// [helpers] Authenticate the device against the upload session in the URL, reply an error response if it fails
+func (app *application) authorizeUploadSession(w http.ResponseWriter, r *http.Request) (UploadSession, bool) {
+	id := httprouter.ParamsFromContext(r.Context()).ByName("id")
+
+	session, ok, err := verifyUploadSession(app.store, r, id)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return session, false
+	}
+	if !ok {
+		app.authFailed(r)
+		app.response(w, http.StatusNotFound, map[string]any {"message": "Invalid upload session"})
+		return session, false
+	}
+
+	return session, true
 }
 
-// [helpers] Copy a given text to the clipboard
-func copyToClipboard(text string) error {
-	err := clipboard.WriteAll(text)
+// [helpers] Lock an upload session until the returned function is called, so its chunks and its finalization never
+// run at once. The session is read again, since another request may have changed or ended it while this one was waiting
+func (app *application) lockUploadSession(w http.ResponseWriter, id string) (UploadSession, func(), bool) {
+	unlock := lockFile(uploadPartPath(app.config.uploadPartsDir(), id))
+
+	session, err := getUploadSession(app.store, id)
+	if errors.Is(err, ErrUploadSessionNotFound) {
+		unlock()
+		app.response(w, http.StatusNotFound, map[string]any {"message": "Invalid upload session"})
+		return session, nil, false
+	}
 	if err != nil {
-		return err
+		unlock()
+		app.serverError(w, err)
+		return session, nil, false
 	}
 
-	return nil
+	return session, unlock, true
+}
+
+// [helpers] Get the record of the transfer history in the posted form, reply an error response if it is not
+// found or it is not of the given kind
+func (app *application) historyRecord(w http.ResponseWriter, r *http.Request, kind string) (TransferRecord, bool) {
+	var form historyActionForm
+
+	err := app.decodePostFormUrlEncoded(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return TransferRecord{}, false
+	}
+
+	record, err := getTransferRecord(app.store, form.Id)
+	if errors.Is(err, ErrTransferRecordNotFound) {
+		app.notFound(w)
+		return record, false
+	}
+	if err != nil {
+		app.serverError(w, err)
+		return record, false
+	}
+
+	if record.Kind != kind {
+		app.clientError(w, http.StatusBadRequest)
+		return record, false
+	}
+
+	return record, true
+}
+
+// [helpers] Open a URL that passed the URL policy, or keep it for the user to confirm on this PC first.
+// The outcome is recorded in the transfer history and returned
+func (app *application) openURL(deviceId, url string, confirm bool) string {
+	if confirm {
+		app.pendingURLs.add(deviceId, url)
+		app.recordURL(deviceId, url, URL_PENDING)
+		app.notifyPage("/urls", EVENT_URL_PENDING, map[string]any {"device": deviceId, "url": url})
+		return URL_PENDING
+	}
+
+	err := app.platform.OpenURL(url)
+	if err != nil {
+		app.errorLog.Println("Failed to open the URL:", err)
+		app.recordURL(deviceId, url, URL_FAILED)
+		return URL_FAILED
+	}
+
+	app.recordURL(deviceId, url, URL_OPENED)
+	return URL_OPENED
 }
- 
+
+// [helpers] Open a given folder, failures are only logged since the files are already saved
+func (app *application) revealFolder(path string) {
+	err := app.platform.RevealFolder(path)
+	if err != nil {
+		app.errorLog.Println("Failed to open the folder:", err)
+	}
+}
+
 
 /* --- MISCELLANEOUS --- */
 
@@ -330,19 +623,90 @@ func readJSONFile(data any, path string) error {
 	return nil
 }
 
-// [helpers] Save data to a specific JSON file
+// [helpers] Save data to a specific JSON file. The data is written to a temporary file first and then renamed,
+// so the file is never left half-written if the program crashes
 func writeJSONFile(data any, path string) error {
 	updatedFile, err := json.MarshalIndent(data, "", " ")
 	if err != nil {
 		return err
 	}
 
-	err = os.WriteFile(path, updatedFile, 0644)
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(tmp.Name())
 
-	return nil
+	_, err = tmp.Write(updatedFile)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(tmp.Name(), 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Locks of the files that are being read or updated, keyed by their absolute paths
+var fileLocks sync.Map
+
+// [helpers] Get the lock of a file, keyed by its absolute path
+func fileLock(path string) *sync.RWMutex {
+	mu, _ := fileLocks.LoadOrStore(fileLockKey(path), &sync.RWMutex{})
+
+	return mu.(*sync.RWMutex)
+}
+
+// [helpers] Get the absolute path a file lock is keyed by
+func fileLockKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+
+	return path
+}
+
+// [helpers] Lock a file for a read-modify-write update within this process, the returned function unlocks it
+func lockFile(path string) (unlock func()) {
+	mu := fileLock(path)
+	mu.Lock()
+
+	return mu.Unlock
+}
+
+// [helpers] Forget the lock of a file that is removed, so the locks do not pile up. A request still waiting for it
+// gets the lock after the holder unlocks it, so it must check that the file still exists
+func forgetFileLock(path string) {
+	fileLocks.Delete(fileLockKey(path))
+}
+
+// [helpers] Lock a file for reading within this process, other readers are not blocked. The returned function unlocks it
+func rlockFile(path string) (unlock func()) {
+	mu := fileLock(path)
+	mu.RLock()
+
+	return mu.RUnlock
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r		io.Reader
+	n		int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
 }
 
 // [helpers] XOR text with key
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+// [tests] Get the names of the files in a folder
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	return names
+}
+
+func TestSaveFilesSizeLimit(t *testing.T) {
+	tests := []struct {
+		name				string
+		maxSize			int64
+		parts				[]formPart
+		wantErr			error
+		wantSaved		[]string
+	}{
+		{"within the limit", 3, []formPart{{name: "file", fileName: "a.txt", content: "abc"}}, nil, []string{"a.txt"}},
+		{"over the limit", 3, []formPart{{name: "file", fileName: "a.txt", content: "abcd"}}, ErrFileTooLarge, []string{}},
+		{"over the limit after a saved file", 3,
+			[]formPart{{name: "file", fileName: "a.txt", content: "abc"}, {name: "file", fileName: "b.txt", content: "abcd"}}, ErrFileTooLarge, []string{}},
+		{"no limit", 0, []formPart{{name: "file", fileName: "a.txt", content: strings.Repeat("a", 1 << 16)}}, nil, []string{"a.txt"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := t.TempDir()
+			st := settingsData{Dst: dst, OnConflict: CONFLICT_RENAME, MaxFileSize: tt.maxSize}
+
+			_, err := saveFiles(multipartReader(t, tt.parts...), st, DeviceInfo{Identifier: "dev1"}, nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+
+			// Neither the rejected files nor their temporary files are left in the destination folder
+			if names := dirNames(t, dst); strings.Join(names, ",") != strings.Join(tt.wantSaved, ",") {
+				t.Errorf("got %v in the destination folder, want %v", names, tt.wantSaved)
+			}
+		})
+	}
+}
+
+func TestSaveFilesAborted(t *testing.T) {
+	dst := t.TempDir()
+	st := settingsData{Dst: dst, OnConflict: CONFLICT_RENAME}
+
+	// The connection drops in the middle of the second file
+	body, contentType := multipartForm(t,
+		formPart{name: "file", fileName: "a.txt", content: "abc"},
+		formPart{name: "file", fileName: "b.txt", content: strings.Repeat("b", 1 << 16)},
+	)
+	r := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(body.String()[:body.Len() / 2]))
+	r.Header.Set("Content-Type", contentType)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := saveFiles(mr, st, DeviceInfo{Identifier: "dev1"}, nil)
+	if err == nil {
+		t.Fatal("got no error for a truncated upload")
+	}
+	if len(content.Saved) != 0 {
+		t.Errorf("got saved files %+v, want none", content.Saved)
+	}
+	if names := dirNames(t, dst); len(names) != 0 {
+		t.Errorf("got %v in the destination folder, want it empty", names)
+	}
+}
+
+func TestUploadRequestSizeLimit(t *testing.T) {
+	dst := t.TempDir()
+	app, _ := newTestApp(settingsData{Dst: dst, OnConflict: CONFLICT_RENAME, MaxRequestSize: 1 << 10})
+	saveTestDevice(t, app, nil)
+
+	w := uploadRequest(t, app, formPart{name: "file", fileName: "a.txt", content: strings.Repeat("a", 2 << 10)})
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("got status %d, want %d: %s", w.Code, http.StatusRequestEntityTooLarge, w.Body)
+	}
+	if names := dirNames(t, dst); len(names) != 0 {
+		t.Errorf("got %v in the destination folder, want it empty", names)
+	}
+
+	w = uploadRequest(t, app, formPart{name: "file", fileName: "a.txt", content: "abc"})
+	if w.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+}
+
+func TestSettingsUploadLimits(t *testing.T) {
+	dst := t.TempDir()
+
+	tests := []struct {
+		name				string
+		maxFile			string
+		maxRequest	string
+		wantStatus	int
+		wantFile		int64
+		wantRequest	int64
+	}{
+		{"limits", "10", "100", http.StatusOK, 10 << 20, 100 << 20},
+		{"no limits", "0", "0", http.StatusOK, 0, 0},
+		{"negative", "-1", "0", http.StatusBadRequest, 1, 1},
+		{"too large", "9223372036854775807", "0", http.StatusBadRequest, 1, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _ := newTestApp(settingsData{MaxFileSize: 1, MaxRequestSize: 1})
+
+			w := postForm(app.settingsPost, url.Values{
+				"dst": {dst},
+				"conflict": {CONFLICT_RENAME},
+				"tokenMode": {TOKEN_MODE_SINGLE},
+				"sessionMinutes": {"10"},
+				"urlSchemes": {"https"},
+				"maxFileSize": {tt.maxFile},
+				"maxRequestSize": {tt.maxRequest},
+			})
+			if w.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+
+			st, _ := app.store.Settings()
+			if st.MaxFileSize != tt.wantFile || st.MaxRequestSize != tt.wantRequest {
+				t.Errorf("got limits %d and %d, want %d and %d", st.MaxFileSize, st.MaxRequestSize, tt.wantFile, tt.wantRequest)
+			}
+		})
+	}
+
+	// The page shows a limit below 1MB as 1MB, not as no limit
+	if got := sizeInMB(1); got != 1 {
+		t.Errorf("got %d MB for 1 byte, want 1", got)
+	}
+	if got := sizeInMB(3 << 20); got != 3 {
+		t.Errorf("got %d MB for 3MB, want 3", got)
+	}
+}
+
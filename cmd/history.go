@@ -0,0 +1,179 @@
+package main
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+/* --- TRANSFER HISTORY --- */
+
+const MAX_HISTORY_RECORDS int = 1000
+
+// Bytes of a received text kept in the history, the history file is rewritten on every transfer
+const MAX_HISTORY_TEXT int = 4 << 10
+
+const (
+	TRANSFER_URL string = "url"
+	TRANSFER_TEXT string = "text"
+	TRANSFER_FILE string = "file"
+)
+
+const (
+	URL_OPENED string = "opened"
+	URL_PENDING string = "pending"
+	URL_DISMISSED string = "dismissed"
+	URL_BLOCKED string = "blocked"
+	URL_FAILED string = "failed"
+)
+
+const (
+	TEXT_COPIED string = "copied"
+	TEXT_FAILED string = "failed"
+)
+
+const (
+	FILE_SAVED string = "saved"
+	FILE_SKIPPED string = "skipped"
+)
+
+// [history] Add records to the transfer history, only the latest MAX_HISTORY_RECORDS records are kept.
+// The name of the device is saved with them, so a record still shows the sender after the device is removed
+func recordTransfer(store Store, records ...TransferRecord) error {
+	now := time.Now()
+	for i := range records {
+		if records[i].Id == "" {
+			records[i].Id = uuid.NewString()
+		}
+		if records[i].Time.IsZero() {
+			records[i].Time = now
+		}
+		if records[i].DeviceName == "" {
+			device, err := getSavedDevice(store, records[i].DeviceId)
+			if err == nil {
+				records[i].DeviceName = device.Name
+			}
+		}
+	}
+
+	return store.UpdateHistory(func(history *TransferHistory) error {
+		history.Records = append(history.Records, records...)
+		if len(history.Records) > MAX_HISTORY_RECORDS {
+			history.Records = history.Records[len(history.Records)-MAX_HISTORY_RECORDS:]
+		}
+		return nil
+	})
+}
+
+// [history] Get a record of the transfer history by its identifier
+func getTransferRecord(store Store, id string) (TransferRecord, error) {
+	history, err := store.History()
+	if err != nil {
+		return TransferRecord{}, err
+	}
+
+	for _, record := range history.Records {
+		if record.Id != "" && record.Id == id {
+			return record, nil
+		}
+	}
+
+	return TransferRecord{}, ErrTransferRecordNotFound
+}
+
+// [history] Get the records of a given kind (all kinds if empty) that contain 'query' in their sender, value or path,
+// ignoring case. The latest record comes first
+func searchHistory(history TransferHistory, query, kind string) []TransferRecord {
+	query = strings.ToLower(strings.TrimSpace(query))
+
+	records := []TransferRecord{}
+	for i := len(history.Records) - 1; i >= 0; i-- {
+		record := history.Records[i]
+		if kind != "" && record.Kind != kind {
+			continue
+		}
+		if query != "" && !strings.Contains(strings.ToLower(record.DeviceName + "\n" + record.DeviceId + "\n" + record.Value + "\n" + record.Path), query) {
+			continue
+		}
+		records = append(records, record)
+	}
+
+	return records
+}
+
+// [history] Add records to the transfer history, failures are only logged since the transfer itself is done
+func (app *application) recordHistory(records ...TransferRecord) {
+	if len(records) == 0 {
+		return
+	}
+
+	err := recordTransfer(app.store, records...)
+	if err != nil {
+		app.errorLog.Printf("Failed to record the %s in the history: %v\n", records[0].Kind, err)
+	}
+}
+
+// [history] Record the outcome of a URL sent by a device
+func (app *application) recordURL(deviceId, url, status string) {
+	app.recordHistory(TransferRecord{
+		DeviceId: deviceId,
+		Kind: TRANSFER_URL,
+		Value: url,
+		Status: status,
+	})
+}
+
+// [history] Record a text sent by a device and whether it was copied to the clipboard. Only the first
+// MAX_HISTORY_TEXT bytes of the text are kept
+func (app *application) recordText(deviceId, text, status string) {
+	preview, truncated := truncateText(text, MAX_HISTORY_TEXT)
+	app.recordHistory(TransferRecord{
+		DeviceId: deviceId,
+		Kind: TRANSFER_TEXT,
+		Value: preview,
+		Truncated: truncated,
+		Size: int64(len(text)),
+		Status: status,
+	})
+}
+
+// [history] Cut a text to at most 'max' bytes without splitting a character, and get whether it was cut
+func truncateText(text string, max int) (string, bool) {
+	if len(text) <= max {
+		return text, false
+	}
+
+	// Step back to the first byte of the character that would be split
+	for max > 0 && !utf8.RuneStart(text[max]) {
+		max--
+	}
+
+	return text[:max], true
+}
+
+// [history] Record the files received from a device, the skipped ones have no path
+func (app *application) recordFiles(deviceId string, saved []savedFile, skipped []string) {
+	records := []TransferRecord{}
+	for _, file := range saved {
+		records = append(records, TransferRecord{
+			DeviceId: deviceId,
+			Kind: TRANSFER_FILE,
+			Value: file.Name,
+			Path: file.Path,
+			Size: file.Size,
+			Status: FILE_SAVED,
+		})
+	}
+	for _, name := range skipped {
+		records = append(records, TransferRecord{
+			DeviceId: deviceId,
+			Kind: TRANSFER_FILE,
+			Value: name,
+			Status: FILE_SKIPPED,
+		})
+	}
+
+	app.recordHistory(records...)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestHistoryActions(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "photo.jpg")
+	err := os.WriteFile(file, []byte("jpg"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := []TransferRecord{
+		{Id: "text", Kind: TRANSFER_TEXT, Value: "hello"},
+		{Id: "url", Kind: TRANSFER_URL, Value: "https://example.com/page"},
+		{Id: "blocked", Kind: TRANSFER_URL, Value: "javascript:alert(1)"},
+		{Id: "file", Kind: TRANSFER_FILE, Value: "photo.jpg", Path: file},
+		{Id: "moved", Kind: TRANSFER_FILE, Value: "gone.jpg", Path: filepath.Join(t.TempDir(), "gone.jpg")},
+	}
+
+	tests := []struct {
+		name				string
+		handler			func(app *application) http.HandlerFunc
+		id					string
+		platformErr	error
+		wantStatus	int
+		wantCalls		[]platformCall
+	}{
+		{"copy text", func(app *application) http.HandlerFunc { return app.copyHistoryText }, "text", nil, http.StatusOK,
+			[]platformCall{{Action: "SetClipboard", Args: []string{"hello"}}}},
+		{"copy a URL record", func(app *application) http.HandlerFunc { return app.copyHistoryText }, "url", nil, http.StatusBadRequest, nil},
+		{"copy unknown record", func(app *application) http.HandlerFunc { return app.copyHistoryText }, "missing", nil, http.StatusNotFound, nil},
+		{"clipboard fails", func(app *application) http.HandlerFunc { return app.copyHistoryText }, "text", errors.New("no clipboard"), http.StatusInternalServerError,
+			[]platformCall{{Action: "SetClipboard", Args: []string{"hello"}}}},
+		{"open URL", func(app *application) http.HandlerFunc { return app.openHistoryURL }, "url", nil, http.StatusOK,
+			[]platformCall{{Action: "OpenURL", Args: []string{"https://example.com/page"}}}},
+		{"open blocked URL", func(app *application) http.HandlerFunc { return app.openHistoryURL }, "blocked", nil, http.StatusForbidden, nil},
+		{"reveal file", func(app *application) http.HandlerFunc { return app.revealHistoryFile }, "file", nil, http.StatusOK,
+			[]platformCall{{Action: "RevealFile", Args: []string{file}}}},
+		{"reveal moved file", func(app *application) http.HandlerFunc { return app.revealHistoryFile }, "moved", nil, http.StatusNotFound, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, platform := newTestApp(settingsData{})
+			platform.Err = tt.platformErr
+
+			err := recordTransfer(app.store, records...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := postForm(tt.handler(app), url.Values{"id": {tt.id}})
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+
+			if !slices.EqualFunc(platform.Calls, tt.wantCalls, func(a, b platformCall) bool {
+				return a.Action == b.Action && slices.Equal(a.Args, b.Args)
+			}) {
+				t.Errorf("got platform calls %+v, want %+v", platform.Calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestTruncateText(t *testing.T) {
+	tests := []struct {
+		name			string
+		text			string
+		max				int
+		want			string
+		truncated	bool
+	}{
+		{"short", "hello", 8, "hello", false},
+		{"exact", "hello", 5, "hello", false},
+		{"long", "hello world", 5, "hello", true},
+		{"split character", "héllo", 2, "h", true},
+		{"after a character", "héllo", 3, "hé", true},
+		{"split first character", "日本", 2, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, truncated := truncateText(tt.text, tt.max)
+			if got != tt.want || truncated != tt.truncated {
+				t.Errorf("got %q and %v, want %q and %v", got, truncated, tt.want, tt.truncated)
+			}
+		})
+	}
+}
+
+func TestRecordLongText(t *testing.T) {
+	app, platform := newTestApp(settingsData{})
+
+	text := strings.Repeat("ข้อความ", MAX_HISTORY_TEXT)
+	app.recordText("dev1", text, TEXT_COPIED)
+
+	history, err := app.store.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history.Records) != 1 {
+		t.Fatalf("got %d records, want 1", len(history.Records))
+	}
+
+	record := history.Records[0]
+	if len(record.Value) > MAX_HISTORY_TEXT || !utf8.ValidString(record.Value) || !strings.HasPrefix(text, record.Value) {
+		t.Errorf("got a text of %d bytes, want a valid start of the text of at most %d bytes", len(record.Value), MAX_HISTORY_TEXT)
+	}
+	if !record.Truncated || record.Size != int64(len(text)) {
+		t.Errorf("got truncated %v and size %d, want true and %d", record.Truncated, record.Size, len(text))
+	}
+
+	// Copying it again copies the kept start and says so
+	w := postForm(app.copyHistoryText, url.Values{"id": {record.Id}})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "start of the text") {
+		t.Errorf("got status %d: %s, want the copy of the start of the text", w.Code, w.Body)
+	}
+	if len(platform.Calls) != 1 || platform.Calls[0].Args[0] != record.Value {
+		t.Errorf("got platform calls %+v, want the kept text copied", platform.Calls)
+	}
+}
+
+func TestOpenHistoryURL(t *testing.T) {
+	tests := []struct {
+		name				string
+		st					settingsData
+		wantStatus	int
+		wantRecord	string	// status of the new record in the history
+		wantPending	int
+	}{
+		{"open", settingsData{}, http.StatusOK, URL_OPENED, 0},
+		{"confirm first", settingsData{URLConfirm: true}, http.StatusAccepted, URL_PENDING, 1},
+		{"blocked by the policy", settingsData{URLDenyDomains: []string{"example.com"}}, http.StatusForbidden, URL_BLOCKED, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, _ := newTestApp(tt.st)
+
+			err := recordTransfer(app.store, TransferRecord{Id: "url", DeviceId: "dev1", Kind: TRANSFER_URL, Value: "https://example.com", Status: URL_OPENED})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := postForm(app.openHistoryURL, url.Values{"id": {"url"}})
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+
+			// Opening it again is recorded like a new URL from the same device
+			history, _ := app.store.History()
+			found := false
+			for _, record := range history.Records {
+				if record.Id != "url" && record.DeviceId == "dev1" && record.Status == tt.wantRecord {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("got history %+v, want a new %s record", history.Records, tt.wantRecord)
+			}
+
+			if pending := app.pendingURLs.list(); len(pending) != tt.wantPending {
+				t.Errorf("got %d URLs waiting for confirmation, want %d", len(pending), tt.wantPending)
+			}
+		})
+	}
+}
@@ -2,40 +2,82 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime/debug"
 	"syscall"
 	"time"
 
+	"github.com/archawitch/iwin/ui"
 	"github.com/go-playground/form/v4"
 	"github.com/grandcat/zeroconf"
 )
 
-/* --- FILE PATHS --- */
+/* --- FILE PATHS (RELATIVE TO THE DATA FOLDER) --- */
 
 const (
-	DEVICES_FILE_PATH string = "configs/devices/saved_devices.json"
-	PENDING_DEVICES_FILE_PATH string = "configs/devices/requested_devices.json"
-	SETTINGS_FILE_PATH string = "configs/settings/settings.json"
-	TOKENS_FILE_PATH string = "configs/auth/tokens.json"
+	DEVICES_FILE_PATH string = "devices/saved_devices.json"
+	DEVICE_AUDIT_FILE_PATH string = "devices/audit.jsonl"
+	PENDING_DEVICES_FILE_PATH string = "devices/requested_devices.json"
+	SETTINGS_FILE_PATH string = "settings/settings.json"
+	TOKENS_FILE_PATH string = "auth/tokens.json"
+	UPLOAD_SESSIONS_FILE_PATH string = "uploads/sessions.json"
+	HISTORY_FILE_PATH string = "history/history.json"
+	UPLOAD_PARTS_DIR string = "uploads/parts"
+	TLS_CERT_FILE_PATH string = "tls/cert.pem"
+	TLS_KEY_FILE_PATH string = "tls/key.pem"
 )
 
 func main() { 
-	port := ":6789"
-	
+	// CONFIG
+	cfg, args, err := loadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = initDataDir(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Serve by default, otherwise run the administration command
+	if len(args) == 0 || args[0] == "serve" {
+		serve(cfg)
+		return
+	}
+
+	err = runCommand(newJSONStore(cfg.dataDir), args, os.Stdin, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "iwin:", err)
+		if errors.Is(err, ErrUsage) {
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+// Start the HTTP server and the mDNS service, and run until the program is interrupted
+func serve(cfg config) {
 	// INFO LOG
-	fileInfo, err := openLogFile("./logs/info.log")
+	fileInfo, err := openLogFile(filepath.Join(cfg.logDir, "info.log"))
 	if err != nil {
 			log.Fatal(err)
 	}
 	infoLog := log.New(fileInfo, "INFO\t", log.Ldate|log.Ltime)
 
 	// ERROR LOG
-	fileErr, err := openLogFile("./logs/errors.log")
+	fileErr, err := openLogFile(filepath.Join(cfg.logDir, "errors.log"))
 	if err != nil {
 			log.Fatal(err)
 	}
@@ -50,16 +92,58 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// TLS CERTIFICATE
+	var tlsConfig *tls.Config
+	var tlsFingerprint string
+	if !cfg.plainHTTP {
+		cert, fingerprint, err := loadOrCreateCertificate(cfg, hostInfo)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion: tls.VersionTLS12,
+		}
+		tlsFingerprint = fingerprint
+	}
+
+	// UI FILES AND TEMPLATE CACHE
+	var uiFS fs.FS = ui.Files
+	if cfg.uiDir != "" {
+		uiFS = os.DirFS(cfg.uiDir)
+	}
+
+	templateCache, err := newTemplateCache(uiFS)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// CREATE mDNS SERVICE
 	var mDNSSvc *zeroconf.Server
 
+	// EVENTS PUSHED TO THE OPEN PAGES
+	events := newEventBroker()
+
 	// CREATE AN APP SERVICE
 	app := &application{
+		config: cfg,
 		infoLog: infoLog,
 		errorLog: errorLog,
 		formDecoder: formDecoder,
 		hostInfo: hostInfo,
+		tlsFingerprint: tlsFingerprint,
+		pairingCodes: newPairingCodes(),
+		challenges: newConnectChallenges(),
+		pendingURLs: newPendingURLs(),
+		limiter: newRateLimiter(),
+		adminSessions: newAdminSessions(),
+		events: events,
+		transfers: newTransfers(events),
 		mDNSSvc: mDNSSvc,
+		store: newJSONStore(cfg.dataDir),
+		platform: newPlatform(cfg.headless),
+		uiFS: uiFS,
+		templateCache: templateCache,
 	}
 
 	// ERROR CHANNEL USED TO SEND ERRORS BETWEEN THE MAIN FUNCTION AND THE SERVERS
@@ -67,18 +151,28 @@ func main() {
 
 	// CREATE HTTP SERVER
 	srv := &http.Server{
-		Addr: port,
+		Addr: cfg.addr,
 		ErrorLog: errorLog,
 		Handler: app.routes(),
+		TLSConfig: tlsConfig,
 		IdleTimeout: time.Minute * 1,
 		ReadTimeout: time.Second * 30,
 		WriteTimeout: time.Minute * 1,
 	}
 
+	// END THE EVENT STREAMS OF THE OPEN PAGES WHEN SHUTTING DOWN
+	srv.RegisterOnShutdown(app.events.close)
+
 	// START HTTP SERVER AND SHUTDOWN IT WHEN THE PROGRAM EXIT
 	go func() {
-		app.infoLog.Println("Starting HTTP server on", port)
-		err := srv.ListenAndServe();
+		var err error
+		if srv.TLSConfig != nil {
+			app.infoLog.Println("Starting HTTPS server on", cfg.addr, "with certificate", tlsFingerprint)
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			app.infoLog.Println("Starting HTTP server on", cfg.addr)
+			err = srv.ListenAndServe()
+		}
 		
 		errCh <- err
 	}()
@@ -118,6 +212,9 @@ func main() {
 		app.infoLog.Println("mDNS service stopped")
 	}()
 	
+	// REMOVE EXPIRED TOKENS AND UPLOAD SESSIONS IN THE BACKGROUND
+	go app.sweepExpired(time.Minute * 1)
+	
 	log.Println("Started the server successfully")
 	
 	// EXIT THE PROGRAM
@@ -43,19 +43,80 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
-// Remove all temporary files associated with a requested form
-func (app *application) clearPostFormData(next http.Handler) http.Handler {
+// Throttle the requests of each IP address, and reject the addresses that are locked out after repeated failed
+// credential checks. The handlers count the failures, see authFailed
+func (app *application) rateLimit(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, wait := app.limiter.allow(rateLimitKey(r))
+		if !ok {
+			app.tooManyRequests(w, wait)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Reject the IP addresses that are locked out without throttling them, so the chunks of a resumable upload
+// can be sent as fast as the device can
+func (app *application) lockoutOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wait := app.limiter.lockedFor(rateLimitKey(r))
+		if wait > 0 {
+			app.tooManyRequests(w, wait)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Reject requests to the local UI from other sites: the Host must be a name of this PC, and the requests that
+// change anything must come from the same origin with the CSRF token of the page
+func (app *application) sameSiteOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.checkHost(r) {
+			app.errorLog.Printf("Rejected a request to the unknown host %q from %s\n", r.Host, r.RemoteAddr)
+			app.clientError(w, http.StatusForbidden)
+			return
+		}
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && (!checkOrigin(r) || !checkCSRFToken(r)) {
+			app.errorLog.Printf("Rejected a cross-site request to %s from %s\n", r.URL.Path, r.RemoteAddr)
+			app.clientError(w, http.StatusForbidden)
+			return
+		}
+
 		next.ServeHTTP(w, r)
+	})
+}
+
+// Allow only the user of the local UI. Without an admin password, it is the PC that is running the server,
+// otherwise it is anyone logged in with the password, so the UI can be used from other PCs
+func (app *application) adminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st, err := app.store.Settings()
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
 
-		// clear contents
-		if r.MultipartForm != nil {
-			err := r.MultipartForm.RemoveAll()
-			if err != nil {
-				app.serverError(w, err)
+		if st.AdminPasswordHash == "" {
+			app.thisPCOnly(next).ServeHTTP(w, r)
+			return
+		}
+
+		cookie, err := r.Cookie(ADMIN_SESSION_COOKIE)
+		if err != nil || !app.adminSessions.valid(cookie.Value, st.AdminPasswordHash) {
+			if r.Method == http.MethodGet {
+				http.Redirect(w, r, "/login", http.StatusSeeOther)
 				return
 			}
+			app.clientError(w, http.StatusUnauthorized)
+			return
 		}
+
+		next.ServeHTTP(w, r)
 	})
 }
 
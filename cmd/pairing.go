@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// How long a pairing code shown on the settings page can be used
+const PAIRING_CODE_TTL = time.Minute * 5
+
+type pairingCode struct {
+	ExpiredAt	time.Time
+	Used			bool
+}
+
+// pairingCodes keeps the one-time codes embedded in the QR code, so only a device
+// that scanned the settings page can register. Used codes are kept until they expire to detect reuse
+type pairingCodes struct {
+	mu			sync.Mutex
+	codes		map[string]pairingCode
+}
+
+// [pairing] Create an empty set of pairing codes
+func newPairingCodes() *pairingCodes {
+	return &pairingCodes{codes: map[string]pairingCode{}}
+}
+
+// [pairing] Generate a new random pairing code that lives for 'ttl', and drop expired codes
+func (p *pairingCodes) mint(ttl time.Duration) (string, error) {
+	code, err := randomString(16)
+	if err != nil {
+		return "", err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for c, pc := range p.codes {
+		if now.After(pc.ExpiredAt) {
+			delete(p.codes, c)
+		}
+	}
+
+	p.codes[code] = pairingCode{ExpiredAt: now.Add(ttl)}
+
+	return code, nil
+}
+
+// [pairing] Mark a pairing code as used, an error is returned if it is unknown, expired or already used
+func (p *pairingCodes) consume(code string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pc, ok := p.codes[code]
+	if !ok || code == "" {
+		return ErrPairingCodeInvalid
+	}
+	if pc.Used {
+		return ErrPairingCodeUsed
+	}
+	if time.Now().After(pc.ExpiredAt) {
+		delete(p.codes, code)
+		return ErrPairingCodeExpired
+	}
+
+	pc.Used = true
+	p.codes[code] = pc
+
+	return nil
+}
+
+// [pairing] Mint a pairing code and get the data of the QR code that carries it, along with the address of this PC.
+// The certificate fingerprint is included when serving HTTPS, so the device can pin it
+func (app *application) pairingQRCode() (string, error) {
+	code, err := app.pairingCodes.mint(PAIRING_CODE_TTL)
+	if err != nil {
+		return "", err
+	}
+
+	fingerprint := app.tlsFingerprint
+	if fingerprint == "" {
+		fingerprint = "-"
+	}
+
+	return app.hostInfo.HostName + " " + app.hostInfo.IPAddr.String() + " " + fingerprint + " " + code, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConsumePairingCode(t *testing.T) {
+	tests := []struct {
+		name		string
+		ttl			time.Duration
+		setup		func(p *pairingCodes, code string)	// runs before the code is consumed
+		code		func(code string) string
+		wantErr	error
+	}{
+		{"valid code", PAIRING_CODE_TTL, nil, func(code string) string { return code }, nil},
+		{"unknown code", PAIRING_CODE_TTL, nil, func(code string) string { return code + "0" }, ErrPairingCodeInvalid},
+		{"empty code", PAIRING_CODE_TTL, nil, func(code string) string { return "" }, ErrPairingCodeInvalid},
+		{"expired code", -time.Second, nil, func(code string) string { return code }, ErrPairingCodeExpired},
+		{"reused code", PAIRING_CODE_TTL, func(p *pairingCodes, code string) { p.consume(code) },
+			func(code string) string { return code }, ErrPairingCodeUsed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newPairingCodes()
+			code, err := p.mint(tt.ttl)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.setup != nil {
+				tt.setup(p, code)
+			}
+
+			err = p.consume(tt.code(code))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMintPairingCodeDropsExpired(t *testing.T) {
+	p := newPairingCodes()
+	expired, _ := p.mint(-time.Second)
+	code, _ := p.mint(PAIRING_CODE_TTL)
+
+	if expired == code {
+		t.Fatalf("got the same code twice: %s", code)
+	}
+	if _, ok := p.codes[expired]; ok {
+		t.Errorf("the expired code was kept after minting a new one")
+	}
+}
+
+func TestAddDeviceRequiresPairingCode(t *testing.T) {
+	app, _ := newTestApp(settingsData{})
+
+	code, err := app.pairingCodes.mint(PAIRING_CODE_TTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name				string
+		id					string
+		code				string
+		wantStatus	int
+	}{
+		{"no code", "dev1", "", http.StatusForbidden},
+		{"wrong code", "dev1", "not-a-code", http.StatusForbidden},
+		{"scanned code", "dev1", code, http.StatusOK},
+		{"code used by another device", "dev2", code, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		w := postForm(app.addDevice, registerForm(t, tt.id, tt.code))
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: got status %d, want %d: %s", tt.name, w.Code, tt.wantStatus, w.Body)
+		}
+	}
+
+	// Only the device with the scanned code is waiting for approval
+	pending, _ := app.store.PendingDevices()
+	if len(pending.Devices) != 1 || pending.Devices[0].Identifier != "dev1" {
+		t.Errorf("got pending devices %+v, want only dev1", pending.Devices)
+	}
+
+	// Each rejected code counts as a failed credential check of the address
+	if c := app.limiter.clients[rateLimitKey(httptest.NewRequest(http.MethodPost, "/", nil))]; c == nil || c.Failures != 3 {
+		t.Errorf("got limit %+v of the address, want 3 failures", c)
+	}
+}
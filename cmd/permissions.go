@@ -0,0 +1,106 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// permissionError is returned when a device uses a capability it is not allowed to
+type permissionError struct {
+	reason	string
+}
+
+func (e permissionError) Error() string {
+	return "permissions: " + e.reason
+}
+
+func (e permissionError) Unwrap() error {
+	return ErrPermissionDenied
+}
+
+// errTooLargeForDevice is returned when a file is larger than the limit of the device
+var errTooLargeForDevice = permissionError{"the file is too large for this device"}
+
+// [permissions] Get the default permissions of a device, which allow everything
+func defaultPermissions() DevicePermissions {
+	return DevicePermissions{Files: true, URL: true, Text: true}
+}
+
+// [permissions] Get the permissions of a device, devices saved without permissions are allowed everything
+func (d DeviceInfo) Perms() DevicePermissions {
+	if d.Permissions == nil {
+		return defaultPermissions()
+	}
+
+	return *d.Permissions
+}
+
+// [permissions] Check whether the device can send a form value with a given name, eg. "url" or "text"
+func (p DevicePermissions) checkValue(name string) error {
+	switch {
+	case name == "url" && !p.URL:
+		return permissionError{"this device is not allowed to open URLs"}
+	case name == "text" && !p.Text:
+		return permissionError{"this device is not allowed to send text"}
+	}
+
+	return nil
+}
+
+// [permissions] Check whether the device can send a file with a given name
+func (p DevicePermissions) checkFile(name string) error {
+	if !p.Files {
+		return permissionError{"this device is not allowed to send files"}
+	}
+
+	if len(p.Extensions) == 0 {
+		return nil
+	}
+
+	ext := normalizeExtension(filepath.Ext(name))
+	for _, allowed := range p.Extensions {
+		if ext != "" && ext == allowed {
+			return nil
+		}
+	}
+
+	return permissionError{"this device is not allowed to send " + name}
+}
+
+// [permissions] Check whether the device can send a file with a given size in bytes
+func (p DevicePermissions) checkSize(size int64) error {
+	if p.MaxSize > 0 && size > p.MaxSize {
+		return errTooLargeForDevice
+	}
+
+	return nil
+}
+
+// [permissions] Check whether the device can send a file with a given name and size in bytes
+func (p DevicePermissions) checkUpload(name string, size int64) error {
+	err := p.checkFile(name)
+	if err != nil {
+		return err
+	}
+
+	return p.checkSize(size)
+}
+
+// [permissions] Normalize an extension to lower case without the leading dot
+func normalizeExtension(ext string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+}
+
+// [permissions] Set the permissions of a saved device with identifier 'id'
+func setDevicePermissions(store Store, id string, perms DevicePermissions) error {
+	return store.UpdateDevices(func(list *DeviceList) error {
+		for i, dv := range list.Devices {
+			if dv.Identifier == id {
+				list.Devices[i].Permissions = &perms
+				return nil
+			}
+		}
+
+		return ErrDeviceNotFound
+	})
+}
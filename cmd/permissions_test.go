@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestSaveFilesPermissions(t *testing.T) {
+	filesOnly := &DevicePermissions{Files: true}
+	textOnly := &DevicePermissions{Text: true}
+	jpgOnly := &DevicePermissions{Files: true, Extensions: []string{"jpg"}}
+
+	tests := []struct {
+		name				string
+		perms				*DevicePermissions
+		parts				[]formPart
+		wantErr			error
+		wantSaved		int
+	}{
+		{"file with empty values", filesOnly,
+			[]formPart{{name: "url"}, {name: "text"}, {name: "file", fileName: "a.txt", content: "a"}}, nil, 1},
+		{"text not allowed", filesOnly,
+			[]formPart{{name: "text", content: "hello"}}, ErrPermissionDenied, 0},
+		{"url not allowed", textOnly,
+			[]formPart{{name: "text", content: "hello"}, {name: "url", content: "https://example.com"}}, ErrPermissionDenied, 0},
+		{"files not allowed", textOnly,
+			[]formPart{{name: "file", fileName: "a.txt", content: "a"}}, ErrPermissionDenied, 0},
+		{"extension allowed", jpgOnly,
+			[]formPart{{name: "file", fileName: "a.JPG", content: "a"}}, nil, 1},
+		{"extension not allowed after a saved file", jpgOnly,
+			[]formPart{{name: "file", fileName: "a.jpg", content: "a"}, {name: "file", fileName: "b.exe", content: "b"}}, ErrPermissionDenied, 0},
+		{"too large for the device", &DevicePermissions{Files: true, MaxSize: 3},
+			[]formPart{{name: "file", fileName: "a.txt", content: "abcd"}}, ErrPermissionDenied, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := t.TempDir()
+			st := settingsData{Dst: dst, OnConflict: CONFLICT_RENAME}
+			device := DeviceInfo{Identifier: "dev1", Permissions: tt.perms}
+
+			content, err := saveFiles(multipartReader(t, tt.parts...), st, device, nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if len(content.Saved) != tt.wantSaved {
+				t.Errorf("got %d saved files, want %d", len(content.Saved), tt.wantSaved)
+			}
+
+			// A rejected upload leaves nothing in the destination folder
+			entries, _ := os.ReadDir(dst)
+			if len(entries) != tt.wantSaved {
+				t.Errorf("got %d files in the destination folder, want %d", len(entries), tt.wantSaved)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+/* --- PLATFORM --- */
+
+// Platform performs the desktop actions of the PC that is running the server
+type Platform interface {
+	OpenURL(url string) error
+	RevealFolder(path string) error
+	RevealFile(path string) error
+	SetClipboard(text string) error
+	Notify(title, message string) error
+}
+
+// [platform] Get the Platform of this PC, or a headless one if the desktop actions are turned off
+func newPlatform(headless bool) Platform {
+	if headless {
+		return headlessPlatform{}
+	}
+
+	return newDesktopPlatform()
+}
+
+// [platform] Run a program with 'stdin' as its input, its output is included in the error if it fails.
+// The input and output are temporary files instead of pipes: programs like xclip, wl-copy and xdg-open leave
+// a child running (to serve the clipboard, or the browser), which keeps the pipes open and Run would wait for it
+func runProgram(stdin string, name string, args ...string) error {
+	input, err := tempFileWith(stdin)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(input.Name())
+	defer input.Close()
+
+	output, err := tempFileWith("")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(output.Name())
+	defer output.Close()
+
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = input
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	err = cmd.Run()
+	if err != nil {
+		out, _ := os.ReadFile(output.Name())
+		return fmt.Errorf("platform: %s failed: %w: %s", name, err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// [platform] Create a temporary file with the given content, ready to be read from the start
+func tempFileWith(content string) (*os.File, error) {
+	f, err := os.CreateTemp("", "iwin-*")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = f.WriteString(content)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	return f, nil
+}
+
+
+/* --- HEADLESS --- */
+
+// headlessPlatform ignores every action, it is used when there is no desktop (eg. a server managed over SSH)
+type headlessPlatform struct{}
+
+func (headlessPlatform) OpenURL(url string) error { return nil }
+func (headlessPlatform) RevealFolder(path string) error { return nil }
+func (headlessPlatform) RevealFile(path string) error { return nil }
+func (headlessPlatform) SetClipboard(text string) error { return nil }
+func (headlessPlatform) Notify(title, message string) error { return nil }
+
+
+/* --- RECORDING --- */
+
+type platformCall struct {
+	Action	string
+	Args		[]string
+}
+
+// recordingPlatform records every action instead of performing it, it is used for tests.
+// Err is returned from every action if it is set
+type recordingPlatform struct {
+	mu			sync.Mutex
+	Calls		[]platformCall
+	Err			error
+}
+
+func (p *recordingPlatform) record(action string, args ...string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.Calls = append(p.Calls, platformCall{Action: action, Args: args})
+
+	return p.Err
+}
+
+func (p *recordingPlatform) OpenURL(url string) error {
+	return p.record("OpenURL", url)
+}
+
+func (p *recordingPlatform) RevealFolder(path string) error {
+	return p.record("RevealFolder", path)
+}
+
+func (p *recordingPlatform) RevealFile(path string) error {
+	return p.record("RevealFile", path)
+}
+
+func (p *recordingPlatform) SetClipboard(text string) error {
+	return p.record("SetClipboard", text)
+}
+
+func (p *recordingPlatform) Notify(title, message string) error {
+	return p.record("Notify", title, message)
+}
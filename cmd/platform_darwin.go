@@ -0,0 +1,35 @@
+package main
+
+// darwinPlatform performs the desktop actions with the macOS tools
+type darwinPlatform struct{}
+
+// [platform] Get the Platform of this PC
+func newDesktopPlatform() Platform {
+	return darwinPlatform{}
+}
+
+func (darwinPlatform) OpenURL(url string) error {
+	return runProgram("", "open", url)
+}
+
+func (darwinPlatform) RevealFolder(path string) error {
+	return runProgram("", "open", path)
+}
+
+func (darwinPlatform) RevealFile(path string) error {
+	return runProgram("", "open", "-R", path)
+}
+
+func (darwinPlatform) SetClipboard(text string) error {
+	return runProgram(text, "pbcopy")
+}
+
+func (darwinPlatform) Notify(title, message string) error {
+	// The texts are passed as arguments, so they are never parsed as a script
+	return runProgram("", "osascript",
+		"-e", "on run argv",
+		"-e", "display notification (item 2 of argv) with title (item 1 of argv)",
+		"-e", "end run",
+		title, message,
+	)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// linuxPlatform performs the desktop actions with the freedesktop tools
+type linuxPlatform struct{}
+
+// [platform] Get the Platform of this PC, a headless one is used when there is no display
+func newDesktopPlatform() Platform {
+	if os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+		return headlessPlatform{}
+	}
+
+	return linuxPlatform{}
+}
+
+func (linuxPlatform) OpenURL(url string) error {
+	return runProgram("", "xdg-open", url)
+}
+
+func (linuxPlatform) RevealFolder(path string) error {
+	return runProgram("", "xdg-open", path)
+}
+
+func (linuxPlatform) RevealFile(path string) error {
+	// There is no common way to select a file in the file managers, so open its folder
+	return runProgram("", "xdg-open", filepath.Dir(path))
+}
+
+func (linuxPlatform) SetClipboard(text string) error {
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		if _, err := exec.LookPath("wl-copy"); err == nil {
+			return runProgram(text, "wl-copy")
+		}
+	}
+
+	if _, err := exec.LookPath("xclip"); err == nil {
+		return runProgram(text, "xclip", "-selection", "clipboard")
+	}
+
+	return runProgram(text, "xsel", "--clipboard", "--input")
+}
+
+func (linuxPlatform) Notify(title, message string) error {
+	return runProgram("", "notify-send", "--", title, message)
+}
@@ -0,0 +1,8 @@
+//go:build !windows && !linux && !darwin
+
+package main
+
+// [platform] Get the Platform of this PC, desktop actions are not supported on this system
+func newDesktopPlatform() Platform {
+	return headlessPlatform{}
+}
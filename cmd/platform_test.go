@@ -0,0 +1,30 @@
+package main
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunProgram(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+
+	// A program that leaves a child running must not be waited for, like xclip serving the clipboard
+	start := time.Now()
+	err := runProgram("", "sh", "-c", "sleep 3 &")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second*2 {
+		t.Errorf("waited %s for the child of the program", elapsed)
+	}
+
+	// The input is passed to the program, and its output is in the error when it fails
+	err = runProgram("hello", "sh", "-c", "cat >&2; exit 1")
+	if err == nil || !strings.Contains(err.Error(), "hello") {
+		t.Errorf("got error %v, want the output of the program", err)
+	}
+}
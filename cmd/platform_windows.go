@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/atotto/clipboard"
+)
+
+// windowsPlatform performs the desktop actions with the Windows shell
+type windowsPlatform struct{}
+
+// [platform] Get the Platform of this PC
+func newDesktopPlatform() Platform {
+	return windowsPlatform{}
+}
+
+func (windowsPlatform) OpenURL(url string) error {
+	// Hand the URL to its default program without a shell, so it is never parsed by cmd
+	return runProgram("", "rundll32", "url.dll,FileProtocolHandler", url)
+}
+
+func (windowsPlatform) RevealFolder(path string) error {
+	// explorer exits with status 1 even when it succeeds, so do not wait for it
+	cmd := exec.Command("explorer", path)
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+	go cmd.Wait()
+
+	return nil
+}
+
+func (windowsPlatform) RevealFile(path string) error {
+	// Open the folder with the file selected, explorer does not wait either. explorer does not parse the quoting
+	// of Go's arguments, so the command line is written as it expects, paths cannot contain quotes on Windows
+	cmd := exec.Command("explorer")
+	cmd.SysProcAttr = &syscall.SysProcAttr{CmdLine: `explorer /select,"` + path + `"`}
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+	go cmd.Wait()
+
+	return nil
+}
+
+func (windowsPlatform) SetClipboard(text string) error {
+	return clipboard.WriteAll(text)
+}
+
+// Show a balloon notification, the texts are passed through the environment so they are never parsed as a script
+const windowsNotifyScript string = `
+Add-Type -AssemblyName System.Windows.Forms
+$icon = New-Object System.Windows.Forms.NotifyIcon
+$icon.Icon = [System.Drawing.SystemIcons]::Information
+$icon.Visible = $true
+$icon.ShowBalloonTip(5000, $env:IWIN_NOTIFY_TITLE, $env:IWIN_NOTIFY_MESSAGE, 'Info')
+Start-Sleep -Seconds 5
+$icon.Dispose()
+`
+
+func (windowsPlatform) Notify(title, message string) error {
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", windowsNotifyScript)
+	cmd.Env = append(os.Environ(), "IWIN_NOTIFY_TITLE="+title, "IWIN_NOTIFY_MESSAGE="+message)
+
+	// The balloon stays for a few seconds, so do not wait for it
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+	go cmd.Wait()
+
+	return nil
+}
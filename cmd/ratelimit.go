@@ -0,0 +1,209 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	RATE_LIMIT_BURST float64 = 20						// requests a client can make at once
+	RATE_LIMIT_PER_SECOND float64 = 1					// requests a client can make per second after the burst
+	LOCKOUT_FAILURES int = 5									// failed credential checks within LOCKOUT_DURATION before a client is locked out
+	LOCKOUT_DURATION time.Duration = time.Minute * 15
+)
+
+type clientLimit struct {
+	Tokens				float64
+	UpdatedAt			time.Time
+	Failures			int
+	FailedAt			time.Time
+	LockedUntil		time.Time
+}
+
+// blockedClient is a client that is locked out, its key is "ip:<address>". Device identifiers are sent before
+// they are authenticated, so devices are throttled but never locked out, or anyone could lock a device out with its identifier
+type blockedClient struct {
+	Key						string
+	LockedUntil		time.Time
+}
+
+// rateLimiter throttles the requests of each client with a token bucket, and locks a client out
+// for a while after repeated failures. Clients are kept in memory only
+type rateLimiter struct {
+	mu				sync.Mutex
+	clients		map[string]*clientLimit
+}
+
+// [ratelimit] Create a rate limiter without any clients
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{clients: map[string]*clientLimit{}}
+}
+
+// [ratelimit] Get the client with a given key, its bucket is refilled for the time since its last request.
+// The caller must hold the lock
+func (l *rateLimiter) client(key string, now time.Time) *clientLimit {
+	c, ok := l.clients[key]
+	if !ok {
+		c = &clientLimit{Tokens: RATE_LIMIT_BURST, UpdatedAt: now}
+		l.clients[key] = c
+	}
+
+	c.Tokens = math.Min(RATE_LIMIT_BURST, c.Tokens + now.Sub(c.UpdatedAt).Seconds() * RATE_LIMIT_PER_SECOND)
+	c.UpdatedAt = now
+
+	return c
+}
+
+// [ratelimit] Take a request from the bucket of a client. If it is locked out or has made too many requests,
+// nothing is taken and how long to wait is returned
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	c := l.client(key, now)
+	if now.Before(c.LockedUntil) {
+		return false, c.LockedUntil.Sub(now)
+	}
+	if c.Tokens < 1 {
+		return false, time.Duration((1 - c.Tokens) / RATE_LIMIT_PER_SECOND * float64(time.Second))
+	}
+
+	c.Tokens--
+
+	return true, 0
+}
+
+// [ratelimit] Count a failed request of a client, true is returned if it reached LOCKOUT_FAILURES and is locked out.
+// Failures are forgotten once a client has not failed for LOCKOUT_DURATION, successful requests do not reset them,
+// so they cannot be used to get more guesses
+func (l *rateLimiter) fail(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	c := l.client(key, now)
+	if now.Sub(c.FailedAt) > LOCKOUT_DURATION {
+		c.Failures = 0
+	}
+	c.Failures++
+	c.FailedAt = now
+	if c.Failures < LOCKOUT_FAILURES {
+		return false
+	}
+
+	c.Failures = 0
+	c.LockedUntil = now.Add(LOCKOUT_DURATION)
+
+	return true
+}
+
+// [ratelimit] Get how long a client is still locked out, 0 if it is not
+func (l *rateLimiter) lockedFor(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c, ok := l.clients[key]
+	if !ok {
+		return 0
+	}
+
+	return max(0, time.Until(c.LockedUntil))
+}
+
+// [ratelimit] Lift the lockout of a client, false is returned if it is not locked out
+func (l *rateLimiter) unblock(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c, ok := l.clients[key]
+	if !ok || !time.Now().Before(c.LockedUntil) {
+		return false
+	}
+	c.LockedUntil = time.Time{}
+	c.Failures = 0
+
+	return true
+}
+
+// [ratelimit] Get the clients that are locked out, sorted by key
+func (l *rateLimiter) blocked() []blockedClient {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	clients := []blockedClient{}
+	for key, c := range l.clients {
+		if now.Before(c.LockedUntil) {
+			clients = append(clients, blockedClient{Key: key, LockedUntil: c.LockedUntil})
+		}
+	}
+	slices.SortFunc(clients, func(a, b blockedClient) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+
+	return clients
+}
+
+// [ratelimit] Forget the clients that have a full bucket, no recent failures and no lockout, and get how many were forgotten
+func (l *rateLimiter) sweep() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	count := 0
+	for key := range l.clients {
+		c := l.client(key, now)
+		if c.Tokens >= RATE_LIMIT_BURST && now.Sub(c.FailedAt) > LOCKOUT_DURATION && !now.Before(c.LockedUntil) {
+			delete(l.clients, key)
+			count++
+		}
+	}
+
+	return count
+}
+
+
+/* --- REQUESTS --- */
+
+// [ratelimit] Get the rate limiter key of a request, its IP address
+func rateLimitKey(r *http.Request) string {
+	return "ip:" + remoteIP(r)
+}
+
+// [ratelimit] Get the rate limiter key of a device, it is only charged once the credentials of the request identify
+// the device, so nobody can use up the requests of a device by sending its identifier
+func deviceLimitKey(deviceId string) string {
+	return "device:" + deviceId
+}
+
+// [ratelimit] Count a failed check of a token, signature, pairing code, session secret or password, the IP address
+// of the request is locked out after LOCKOUT_FAILURES of them. Requests that fail for other reasons are not counted
+func (app *application) authFailed(r *http.Request) {
+	key := rateLimitKey(r)
+	if app.limiter.fail(key) {
+		app.errorLog.Printf("Locked out %s for %s after %d failed credential checks\n", key, LOCKOUT_DURATION, LOCKOUT_FAILURES)
+	}
+}
+
+// [ratelimit] Take a request from the bucket of an authenticated device, so a device cannot flood the server from
+// several IP addresses. A Too Many Requests (429) response is replied and false is returned if it made too many
+func (app *application) allowDevice(w http.ResponseWriter, deviceId string) bool {
+	ok, wait := app.limiter.allow(deviceLimitKey(deviceId))
+	if !ok {
+		app.tooManyRequests(w, wait)
+	}
+
+	return ok
+}
+
+// [ratelimit] Reply a Too Many Requests (429) response with how long to wait
+func (app *application) tooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	app.response(w, http.StatusTooManyRequests, map[string]any {"message": "Too many requests, please try again later"})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter()
+
+	for i := 0; i < int(RATE_LIMIT_BURST); i++ {
+		if ok, _ := l.allow("ip:1.2.3.4"); !ok {
+			t.Fatalf("request %d was throttled within the burst", i+1)
+		}
+	}
+	if ok, wait := l.allow("ip:1.2.3.4"); ok || wait <= 0 {
+		t.Errorf("got %v and wait %s after the burst, want throttled", ok, wait)
+	}
+	if ok, _ := l.allow("ip:5.6.7.8"); !ok {
+		t.Errorf("another client was throttled")
+	}
+
+	for i := 1; i < LOCKOUT_FAILURES; i++ {
+		if l.fail("ip:5.6.7.8") {
+			t.Fatalf("locked out after %d failures", i)
+		}
+	}
+	if !l.fail("ip:5.6.7.8") || l.lockedFor("ip:5.6.7.8") <= 0 {
+		t.Fatalf("not locked out after %d failures", LOCKOUT_FAILURES)
+	}
+	if ok, _ := l.allow("ip:5.6.7.8"); ok {
+		t.Errorf("a locked out client was allowed")
+	}
+
+	if !l.unblock("ip:5.6.7.8") {
+		t.Fatal("could not unblock the client")
+	}
+	if ok, _ := l.allow("ip:5.6.7.8"); !ok {
+		t.Errorf("an unblocked client was not allowed")
+	}
+}
+
+func TestAllowDevice(t *testing.T) {
+	app, _ := newTestApp(settingsData{})
+
+	// The device is throttled wherever its requests come from
+	for i := 0; i < int(RATE_LIMIT_BURST); i++ {
+		if !app.allowDevice(httptest.NewRecorder(), "dev1") {
+			t.Fatalf("request %d was throttled within the burst", i+1)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	if app.allowDevice(w, "dev1") || w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("got status %d after the burst, want %d with Retry-After", w.Code, http.StatusTooManyRequests)
+	}
+	if !app.allowDevice(httptest.NewRecorder(), "dev2") {
+		t.Errorf("another device was throttled")
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"io/fs"
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
@@ -10,26 +11,64 @@ import (
 func (app *application) routes() http.Handler {
 	router := httprouter.New()
 
+	staticFS, err := fs.Sub(app.uiFS, "static")
+	if err != nil {
+		panic(err)
+	}
+
 	router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		app.notFound(w)
 	})
 
-	router.ServeFiles("/static/*filepath", http.Dir("ui/static"))
+	router.ServeFiles("/static/*filepath", http.FS(staticFS))
+
+	public := alice.New(app.rateLimit)
+
+	router.Handler(http.MethodPost, "/addDevice", public.ThenFunc(app.addDevice))
+	router.Handler(http.MethodPost, "/challenge", public.ThenFunc(app.challenge))
+	router.Handler(http.MethodPost, "/connect", public.ThenFunc(app.connect))
+	router.Handler(http.MethodPost, "/disconnect", public.ThenFunc(app.disconnect))
+	router.Handler(http.MethodPost, "/upload", public.ThenFunc(app.upload))
+	router.Handler(http.MethodPost, "/upload/session", public.ThenFunc(app.createUploadSession))
+	router.Handler(http.MethodPost, "/upload/session/:id/finalize", public.ThenFunc(app.finalizeUploadSession))
+
+	// The chunks of a resumable upload are not throttled, an invalid session secret still counts as a failure
+	chunks := alice.New(app.lockoutOnly)
+
+	router.Handler(http.MethodGet, "/upload/session/:id", chunks.ThenFunc(app.uploadSessionStatus))
+	router.Handler(http.MethodPut, "/upload/session/:id", chunks.ThenFunc(app.uploadChunk))
+
+	login := alice.New(app.rateLimit, app.sameSiteOnly)
 
-	router.HandlerFunc(http.MethodPost, "/addDevice", app.addDevice)
-	router.HandlerFunc(http.MethodPost, "/connect", app.connect)
-	router.HandlerFunc(http.MethodPost, "/upload", app.upload)
+	router.Handler(http.MethodGet, "/login", login.ThenFunc(app.login))
+	router.Handler(http.MethodPost, "/login", login.ThenFunc(app.loginPost))
 
-	local := alice.New(app.thisPCOnly)
+	local := alice.New(app.sameSiteOnly, app.adminOnly)
 
 	router.Handler(http.MethodGet, "/", local.ThenFunc(app.settings))
 	router.Handler(http.MethodPost, "/settings", local.ThenFunc(app.settingsPost))
 	router.Handler(http.MethodGet, "/devices", local.ThenFunc(app.getDevices))
+	router.Handler(http.MethodGet, "/events", local.ThenFunc(app.streamEvents))
+	router.Handler(http.MethodPost, "/pairing", local.ThenFunc(app.newPairingCode))
+	router.Handler(http.MethodGet, "/transfers", local.ThenFunc(app.getTransfers))
+	router.Handler(http.MethodPost, "/transfers/cancel", local.ThenFunc(app.cancelTransfer))
 	router.Handler(http.MethodPost, "/refresh", local.ThenFunc(app.refresh))
+	router.Handler(http.MethodPost, "/logout", local.ThenFunc(app.logout))
 	router.Handler(http.MethodPost, "/verify", local.ThenFunc(app.verifyDevicePost))
 	router.Handler(http.MethodPost, "/removeDevice", local.ThenFunc(app.removeDevice))
+	router.Handler(http.MethodPost, "/unblock", local.ThenFunc(app.unblockClient))
+	router.Handler(http.MethodPost, "/permissions", local.ThenFunc(app.devicePermissionsPost))
+	router.Handler(http.MethodGet, "/urls", local.ThenFunc(app.getPendingURLs))
+	router.Handler(http.MethodPost, "/urls", local.ThenFunc(app.pendingURLPost))
+	router.Handler(http.MethodPost, "/rules", local.ThenFunc(app.addRule))
+	router.Handler(http.MethodPost, "/rules/remove", local.ThenFunc(app.removeRule))
+	router.Handler(http.MethodPost, "/rules/up", local.ThenFunc(app.moveRuleUp))
+	router.Handler(http.MethodGet, "/history", local.ThenFunc(app.getHistory))
+	router.Handler(http.MethodPost, "/history/reveal", local.ThenFunc(app.revealHistoryFile))
+	router.Handler(http.MethodPost, "/history/copy", local.ThenFunc(app.copyHistoryText))
+	router.Handler(http.MethodPost, "/history/open", local.ThenFunc(app.openHistoryURL))
 
-	middleware := alice.New(app.recoverPanic, app.logRequest, secureHeaders, app.clearPostFormData)
+	middleware := alice.New(app.recoverPanic, app.logRequest, secureHeaders)
 	
 	return middleware.Then(router)
 }
\ No newline at end of file
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"mime"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/* --- ROUTING RULES --- */
+
+// Variables of the destination path of a rule, eg. "{dst}/{device}/{yyyy}-{mm}"
+var ruleVariables = map[string]bool{
+	"dst": true, "device": true, "device_id": true, "yyyy": true, "mm": true, "dd": true, "ext": true,
+}
+
+var ruleVariablePattern = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// The largest size in MB that can be converted to bytes without overflowing
+const MAX_SIZE_MB int64 = math.MaxInt64 >> 20
+
+// ruleError is returned when a routing rule is not valid
+type ruleError struct {
+	reason	string
+}
+
+func (e ruleError) Error() string {
+	return "rules: " + e.reason
+}
+
+func (e ruleError) Unwrap() error {
+	return ErrInvalidRule
+}
+
+// routedFile is what the routing rules know about a received file
+type routedFile struct {
+	DeviceId		string
+	DeviceName	string
+	Name				string
+	MimeType		string
+	Size				int64		// -1 when the file is not received yet
+}
+
+// [rules] Get the MIME type of a file from the type sent by the device, or from its extension when it is missing
+func fileMimeType(name, contentType string) string {
+	mimeType, _, err := mime.ParseMediaType(contentType)
+	if err == nil && mimeType != "application/octet-stream" {
+		return mimeType
+	}
+
+	mimeType, _, err = mime.ParseMediaType(mime.TypeByExtension(filepath.Ext(name)))
+	if err == nil {
+		return mimeType
+	}
+
+	return "application/octet-stream"
+}
+
+// [rules] Check whether a file matches every condition of the rule. The size is not known before the file is received,
+// so 'known' is false when the outcome depends on it
+func (rule RoutingRule) match(f routedFile) (matched, known bool) {
+	if rule.Device != "" && !strings.EqualFold(rule.Device, f.DeviceId) && !strings.EqualFold(rule.Device, f.DeviceName) {
+		return false, true
+	}
+
+	if len(rule.Extensions) > 0 {
+		ext := normalizeExtension(filepath.Ext(f.Name))
+		found := false
+		for _, allowed := range rule.Extensions {
+			if ext != "" && ext == allowed {
+				found = true
+			}
+		}
+		if !found {
+			return false, true
+		}
+	}
+
+	if rule.MimeType != "" {
+		prefix, isWildcard := strings.CutSuffix(rule.MimeType, "*")
+		if isWildcard && !strings.HasPrefix(f.MimeType, prefix) || !isWildcard && f.MimeType != rule.MimeType {
+			return false, true
+		}
+	}
+
+	if rule.Pattern != "" {
+		ok, _ := filepath.Match(strings.ToLower(rule.Pattern), strings.ToLower(f.Name))
+		if !ok {
+			return false, true
+		}
+	}
+
+	if rule.MinSize > 0 || rule.MaxSize > 0 {
+		if f.Size < 0 {
+			return false, false
+		}
+		if rule.MinSize > 0 && f.Size < rule.MinSize || rule.MaxSize > 0 && f.Size > rule.MaxSize {
+			return false, true
+		}
+	}
+
+	return true, true
+}
+
+// [rules] Get the folder to save a file in, from the first rule it matches or the destination folder if none does.
+// An empty path is returned when it depends on the size of a file that is not received yet
+func routeFile(st settingsData, f routedFile, now time.Time) string {
+	for _, rule := range st.Rules {
+		matched, known := rule.match(f)
+		if !known {
+			return ""
+		}
+		if matched {
+			return expandRulePath(rule.Path, st.Dst, f, now)
+		}
+	}
+
+	return st.Dst
+}
+
+// [rules] Fill in the environment variables, a leading ~ and then the variables of a destination path, relative paths
+// are in the destination folder. The device names are sanitized like file names, so they can never point outside the folder
+func expandRulePath(path, dst string, f routedFile, now time.Time) string {
+	device := f.DeviceName
+	if device == "" {
+		device = f.DeviceId
+	}
+	ext := normalizeExtension(filepath.Ext(f.Name))
+	if ext == "" {
+		ext = "other"
+	}
+
+	path = expandHomeDir(os.ExpandEnv(filepath.FromSlash(path)))
+
+	path = ruleVariablePattern.ReplaceAllStringFunc(path, func(variable string) string {
+		switch strings.Trim(variable, "{}") {
+		case "dst":
+			return dst
+		case "device":
+			return sanitizeFileName(device)
+		case "device_id":
+			return sanitizeFileName(f.DeviceId)
+		case "yyyy":
+			return now.Format("2006")
+		case "mm":
+			return now.Format("01")
+		case "dd":
+			return now.Format("02")
+		case "ext":
+			return sanitizeFileName(ext)
+		}
+		return variable
+	})
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dst, path)
+	}
+
+	return filepath.Clean(path)
+}
+
+// [rules] Replace a leading ~ of a path with the home folder of the user, the path is kept when it is unknown
+func expandHomeDir(path string) string {
+	rest, ok := strings.CutPrefix(path, "~")
+	if !ok || rest != "" && !os.IsPathSeparator(rest[0]) {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+
+	return home + rest
+}
+
+// [rules] Check whether a rule can be saved
+func checkRule(rule RoutingRule) error {
+	if strings.TrimSpace(rule.Path) == "" {
+		return ruleError{"a destination path is required"}
+	}
+
+	for _, match := range ruleVariablePattern.FindAllStringSubmatch(rule.Path, -1) {
+		if !ruleVariables[match[1]] {
+			return ruleError{fmt.Sprintf("{%s} is not a known variable", match[1])}
+		}
+	}
+
+	if rule.MimeType != "" && !strings.Contains(rule.MimeType, "/") {
+		return ruleError{rule.MimeType + " is not a MIME type, eg. image/*"}
+	}
+
+	if _, err := filepath.Match(rule.Pattern, ""); err != nil {
+		return ruleError{rule.Pattern + " is not a valid file name pattern"}
+	}
+
+	if rule.MinSize < 0 || rule.MaxSize < 0 || rule.MaxSize > 0 && rule.MinSize > rule.MaxSize {
+		return ruleError{"the size range is not valid"}
+	}
+
+	return nil
+}
+
+// [rules] Add a routing rule after the existing ones
+func addRoutingRule(store Store, rule RoutingRule) error {
+	err := checkRule(rule)
+	if err != nil {
+		return err
+	}
+
+	rule.Id = uuid.NewString()
+
+	return store.UpdateSettings(func(st *settingsData) error {
+		st.Rules = append(st.Rules, rule)
+		return nil
+	})
+}
+
+// [rules] Remove the routing rule with identifier 'id'
+func removeRoutingRule(store Store, id string) error {
+	return store.UpdateSettings(func(st *settingsData) error {
+		for i, rule := range st.Rules {
+			if rule.Id == id {
+				st.Rules = append(st.Rules[:i], st.Rules[i+1:]...)
+				return nil
+			}
+		}
+
+		return ErrRuleNotFound
+	})
+}
+
+// [rules] Move the routing rule with identifier 'id' before the previous one, so it is checked earlier
+func moveRoutingRuleUp(store Store, id string) error {
+	return store.UpdateSettings(func(st *settingsData) error {
+		for i, rule := range st.Rules {
+			if rule.Id == id {
+				if i > 0 {
+					st.Rules[i-1], st.Rules[i] = st.Rules[i], st.Rules[i-1]
+				}
+				return nil
+			}
+		}
+
+		return ErrRuleNotFound
+	})
+}
+
+// [rules] Describe the conditions of a rule for the settings page
+func (rule RoutingRule) Summary() string {
+	conditions := []string{}
+	if rule.Device != "" {
+		conditions = append(conditions, "from "+rule.Device)
+	}
+	if len(rule.Extensions) > 0 {
+		conditions = append(conditions, "."+strings.Join(rule.Extensions, ", ."))
+	}
+	if rule.MimeType != "" {
+		conditions = append(conditions, rule.MimeType)
+	}
+	if rule.Pattern != "" {
+		conditions = append(conditions, "named "+rule.Pattern)
+	}
+	if rule.MinSize > 0 {
+		conditions = append(conditions, "at least "+formatBytes(rule.MinSize))
+	}
+	if rule.MaxSize > 0 {
+		conditions = append(conditions, "at most "+formatBytes(rule.MaxSize))
+	}
+
+	if len(conditions) == 0 {
+		return "every file"
+	}
+
+	return "files " + strings.Join(conditions, ", ")
+}
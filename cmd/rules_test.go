@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRoutingRulesStore(t *testing.T) {
+	store := newMemoryStore(settingsData{Dst: "/dst"})
+
+	for _, path := range []string{"photos", "documents"} {
+		err := addRoutingRule(store, RoutingRule{Path: path})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := addRoutingRule(store, RoutingRule{Path: "{unknown}"})
+	if !errors.Is(err, ErrInvalidRule) {
+		t.Errorf("got error %v for an unknown variable, want %v", err, ErrInvalidRule)
+	}
+
+	st, _ := store.Settings()
+	if len(st.Rules) != 2 || st.Rules[0].Id == "" {
+		t.Fatalf("got rules %+v, want the 2 valid rules with identifiers", st.Rules)
+	}
+	first, second := st.Rules[0], st.Rules[1]
+
+	err = moveRoutingRuleUp(store, second.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, _ = store.Settings()
+	if st.Rules[0].Id != second.Id || st.Rules[1].Id != first.Id {
+		t.Errorf("got rules %+v, want %s moved first", st.Rules, second.Path)
+	}
+
+	err = removeRoutingRule(store, first.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = removeRoutingRule(store, first.Id)
+	if !errors.Is(err, ErrRuleNotFound) {
+		t.Errorf("got error %v removing a removed rule, want %v", err, ErrRuleNotFound)
+	}
+
+	st, _ = store.Settings()
+	if len(st.Rules) != 1 || st.Rules[0].Id != second.Id {
+		t.Errorf("got rules %+v, want only %s", st.Rules, second.Path)
+	}
+}
+
+func TestRouteFile(t *testing.T) {
+	dst := filepath.FromSlash("/dst")
+	photos := RoutingRule{Extensions: []string{"jpg", "heic"}, Path: "photos"}
+	large := RoutingRule{MinSize: 100, Path: "large"}
+	catchAll := RoutingRule{Path: "other"}
+
+	tests := []struct {
+		name	string
+		rules	[]RoutingRule
+		file	routedFile
+		want	string
+	}{
+		{"no rules", nil, routedFile{Name: "a.jpg"}, dst},
+		{"matching extension", []RoutingRule{photos}, routedFile{Name: "IMG_1.JPG"}, filepath.Join(dst, "photos")},
+		{"other extension", []RoutingRule{photos}, routedFile{Name: "notes.txt"}, dst},
+		{"no extension", []RoutingRule{photos}, routedFile{Name: "jpg"}, dst},
+		{"catch-all rule", []RoutingRule{photos, catchAll}, routedFile{Name: "notes.txt"}, filepath.Join(dst, "other")},
+		{"first matching rule", []RoutingRule{catchAll, photos}, routedFile{Name: "a.jpg"}, filepath.Join(dst, "other")},
+		{"device", []RoutingRule{{Device: "Phone", Path: "phone"}}, routedFile{DeviceName: "phone", Name: "a"}, filepath.Join(dst, "phone")},
+		{"MIME type", []RoutingRule{{MimeType: "image/*", Path: "images"}}, routedFile{Name: "a", MimeType: "image/png"}, filepath.Join(dst, "images")},
+		{"pattern", []RoutingRule{{Pattern: "IMG_*", Path: "camera"}}, routedFile{Name: "img_2.png"}, filepath.Join(dst, "camera")},
+		{"large enough", []RoutingRule{large}, routedFile{Name: "a", Size: 200}, filepath.Join(dst, "large")},
+		{"too small", []RoutingRule{large}, routedFile{Name: "a", Size: 50}, dst},
+		{"size not known yet", []RoutingRule{large}, routedFile{Name: "a", Size: -1}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := routeFile(settingsData{Dst: dst, Rules: tt.rules}, tt.file, time.Now())
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandRulePath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	t.Setenv("IWIN_TEST_DIR", filepath.FromSlash("/media"))
+
+	dst := filepath.FromSlash("/dst")
+	now := time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)
+	file := routedFile{DeviceId: "dev1", DeviceName: "My Phone", Name: "IMG_1.HEIC"}
+
+	tests := []struct {
+		name	string
+		path	string
+		file	routedFile
+		want	string
+	}{
+		{"relative", "photos", file, filepath.Join(dst, "photos")},
+		{"absolute", filepath.Join(home, "photos"), file, filepath.Join(home, "photos")},
+		{"variables", "{dst}/{device}/{yyyy}-{mm}-{dd}/{ext}", file, filepath.Join(dst, "My Phone", "2024-03-05", "heic")},
+		{"device identifier", "{device_id}", file, filepath.Join(dst, "dev1")},
+		{"no extension", "{ext}", routedFile{Name: "README"}, filepath.Join(dst, "other")},
+		{"device name with a path", "{device}", routedFile{DeviceName: "../../etc"}, filepath.Join(dst, "etc")},
+		{"environment variable", "$IWIN_TEST_DIR/{yyyy}", file, filepath.Join(filepath.FromSlash("/media"), "2024")},
+		{"braced environment variable", "${IWIN_TEST_DIR}/photos", file, filepath.Join(filepath.FromSlash("/media"), "photos")},
+		{"home folder", "~", file, home},
+		{"in the home folder", "~/Pictures/{device}", file, filepath.Join(home, "Pictures", "My Phone")},
+		{"tilde in a name", "~photos", file, filepath.Join(dst, "~photos")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := expandRulePath(tt.path, dst, tt.file, now)
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
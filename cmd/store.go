@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+/* --- STORAGE --- */
+
+// Store keeps the server's devices, pending devices, device audit log, tokens, upload sessions, settings and transfer history.
+// Each Update function reads the current data, lets 'fn' modify it and saves it back atomically,
+// nothing is saved if 'fn' returns an error. Updates of the same data are serialized, also across processes
+// for the JSON files, so the CLI can change them while the server is running
+type Store interface {
+	Devices() (DeviceList, error)
+	UpdateDevices(fn func(*DeviceList) error) error
+
+	PendingDevices() (DeviceList, error)
+	UpdatePendingDevices(fn func(*DeviceList) error) error
+
+	// The audit log is append-only
+	AuditLog() ([]AuditEvent, error)
+	AppendAudit(event AuditEvent) error
+
+	Tokens() (TokenList, error)
+	UpdateTokens(fn func(*TokenList) error) error
+
+	UploadSessions() (UploadSessionList, error)
+	UpdateUploadSessions(fn func(*UploadSessionList) error) error
+
+	Settings() (settingsData, error)
+	UpdateSettings(fn func(*settingsData) error) error
+
+	History() (TransferHistory, error)
+	UpdateHistory(fn func(*TransferHistory) error) error
+}
+
+
+/* --- JSON FILES --- */
+
+// jsonStore is the default Store that saves each kind of data in its own JSON file
+type jsonStore struct {
+	devicesPath					string
+	pendingDevicesPath	string
+	auditPath						string
+	tokensPath					string
+	uploadSessionsPath	string
+	settingsPath				string
+	historyPath					string
+}
+
+// [store] Create a Store backed by the JSON files in a given data folder
+func newJSONStore(dataDir string) *jsonStore {
+	return &jsonStore{
+		devicesPath: filepath.Join(dataDir, DEVICES_FILE_PATH),
+		pendingDevicesPath: filepath.Join(dataDir, PENDING_DEVICES_FILE_PATH),
+		auditPath: filepath.Join(dataDir, DEVICE_AUDIT_FILE_PATH),
+		tokensPath: filepath.Join(dataDir, TOKENS_FILE_PATH),
+		uploadSessionsPath: filepath.Join(dataDir, UPLOAD_SESSIONS_FILE_PATH),
+		settingsPath: filepath.Join(dataDir, SETTINGS_FILE_PATH),
+		historyPath: filepath.Join(dataDir, HISTORY_FILE_PATH),
+	}
+}
+
+func (s *jsonStore) Devices() (DeviceList, error) {
+	var list DeviceList
+	err := readStoreFile(&list, s.devicesPath)
+	return list, err
+}
+
+func (s *jsonStore) UpdateDevices(fn func(*DeviceList) error) error {
+	return updateJSONFile(s.devicesPath, fn)
+}
+
+func (s *jsonStore) PendingDevices() (DeviceList, error) {
+	var list DeviceList
+	err := readStoreFile(&list, s.pendingDevicesPath)
+	return list, err
+}
+
+func (s *jsonStore) UpdatePendingDevices(fn func(*DeviceList) error) error {
+	return updateJSONFile(s.pendingDevicesPath, fn)
+}
+
+func (s *jsonStore) AuditLog() ([]AuditEvent, error) {
+	unlock := lockFile(s.auditPath)
+	defer unlock()
+
+	unlockShared, err := lockStoreFile(s.auditPath, false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlockShared()
+
+	events := []AuditEvent{}
+	data, err := os.ReadFile(s.auditPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return events, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Each line is an event, a line cut short by a crash is skipped
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var event AuditEvent
+		if json.Unmarshal(line, &event) == nil {
+			events = append(events, event)
+		}
+	}
+
+	return events, nil
+}
+
+func (s *jsonStore) AppendAudit(event AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	unlock := lockFile(s.auditPath)
+	defer unlock()
+
+	unlockShared, err := lockStoreFile(s.auditPath, true)
+	if err != nil {
+		return err
+	}
+	defer unlockShared()
+
+	file, err := os.OpenFile(s.auditPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
+
+func (s *jsonStore) Tokens() (TokenList, error) {
+	var list TokenList
+	err := readStoreFile(&list, s.tokensPath)
+	return list, err
+}
+
+func (s *jsonStore) UpdateTokens(fn func(*TokenList) error) error {
+	return updateJSONFile(s.tokensPath, fn)
+}
+
+func (s *jsonStore) UploadSessions() (UploadSessionList, error) {
+	var list UploadSessionList
+	err := readStoreFile(&list, s.uploadSessionsPath)
+	return list, err
+}
+
+func (s *jsonStore) UpdateUploadSessions(fn func(*UploadSessionList) error) error {
+	return updateJSONFile(s.uploadSessionsPath, fn)
+}
+
+func (s *jsonStore) Settings() (settingsData, error) {
+	var st settingsData
+	err := readStoreFile(&st, s.settingsPath)
+	return st, err
+}
+
+func (s *jsonStore) UpdateSettings(fn func(*settingsData) error) error {
+	return updateJSONFile(s.settingsPath, fn)
+}
+
+func (s *jsonStore) History() (TransferHistory, error) {
+	var history TransferHistory
+	err := readStoreFile(&history, s.historyPath)
+	return history, err
+}
+
+func (s *jsonStore) UpdateHistory(fn func(*TransferHistory) error) error {
+	return updateJSONFile(s.historyPath, fn)
+}
+
+// [store] Read a JSON file while holding its read lock. Windows cannot rename a file over one that is open,
+// so an update must not replace the file while it is being read, even by another process
+func readStoreFile(data any, path string) error {
+	unlock := rlockFile(path)
+	defer unlock()
+
+	unlockShared, err := lockStoreFile(path, false)
+	if err != nil {
+		return err
+	}
+	defer unlockShared()
+
+	return readJSONFile(data, path)
+}
+
+// [store] Read a JSON file into a value of type T, modify it with 'fn' and save it back.
+// The file is locked during the update, within this process and across processes, so concurrent updates
+// never clobber each other
+func updateJSONFile[T any](path string, fn func(*T) error) error {
+	unlock := lockFile(path)
+	defer unlock()
+
+	unlockShared, err := lockStoreFile(path, true)
+	if err != nil {
+		return err
+	}
+	defer unlockShared()
+
+	var data T
+
+	err = readJSONFile(&data, path)
+	if err != nil {
+		return err
+	}
+
+	err = fn(&data)
+	if err != nil {
+		return err
+	}
+
+	return writeJSONFile(data, path)
+}
@@ -0,0 +1,31 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// [store] Lock the lock file of a store file across processes, shared for reading or exclusive for updating.
+// The returned function unlocks it
+func lockStoreFile(path string, exclusive bool) (unlock func(), err error) {
+	file, err := os.OpenFile(path + ".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+
+	err = syscall.Flock(int(file.Fd()), how)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	// Closing the file releases the lock
+	return func() { file.Close() }, nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// [store] Lock the lock file of a store file across processes, shared for reading or exclusive for updating.
+// The returned function unlocks it
+func lockStoreFile(path string, exclusive bool) (unlock func(), err error) {
+	file, err := os.OpenFile(path + ".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	flags := uint32(0)
+	if exclusive {
+		flags = windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+
+	err = windows.LockFileEx(windows.Handle(file.Fd()), flags, 0, 1, 0, &windows.Overlapped{})
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	// Closing the file releases the lock
+	return func() { file.Close() }, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"slices"
+	"sync"
+)
+
+// memoryStore is a Store that keeps everything in memory, it is used for tests
+type memoryStore struct {
+	mu							sync.Mutex
+	devices					DeviceList
+	pendingDevices	DeviceList
+	audit						[]AuditEvent
+	tokens					TokenList
+	uploadSessions	UploadSessionList
+	settings				settingsData
+	history					TransferHistory
+}
+
+// [store] Create an empty in-memory Store with the given settings
+func newMemoryStore(st settingsData) *memoryStore {
+	return &memoryStore{
+		devices: DeviceList{Devices: []DeviceInfo{}},
+		pendingDevices: DeviceList{Devices: []DeviceInfo{}},
+		tokens: TokenList{Tokens: []Token{}},
+		uploadSessions: UploadSessionList{Sessions: []UploadSession{}},
+		settings: st,
+		history: TransferHistory{Records: []TransferRecord{}},
+	}
+}
+
+func (s *memoryStore) Devices() (DeviceList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return DeviceList{Devices: slices.Clone(s.devices.Devices)}, nil
+}
+
+func (s *memoryStore) UpdateDevices(fn func(*DeviceList) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return updateMemory(&s.devices, fn, func(l DeviceList) DeviceList {
+		return DeviceList{Devices: slices.Clone(l.Devices)}
+	})
+}
+
+func (s *memoryStore) PendingDevices() (DeviceList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return DeviceList{Devices: slices.Clone(s.pendingDevices.Devices)}, nil
+}
+
+func (s *memoryStore) UpdatePendingDevices(fn func(*DeviceList) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return updateMemory(&s.pendingDevices, fn, func(l DeviceList) DeviceList {
+		return DeviceList{Devices: slices.Clone(l.Devices)}
+	})
+}
+
+func (s *memoryStore) AuditLog() ([]AuditEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.audit), nil
+}
+
+func (s *memoryStore) AppendAudit(event AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.audit = append(s.audit, event)
+	return nil
+}
+
+func (s *memoryStore) Tokens() (TokenList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return TokenList{Tokens: slices.Clone(s.tokens.Tokens)}, nil
+}
+
+func (s *memoryStore) UpdateTokens(fn func(*TokenList) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return updateMemory(&s.tokens, fn, func(l TokenList) TokenList {
+		return TokenList{Tokens: slices.Clone(l.Tokens)}
+	})
+}
+
+func (s *memoryStore) UploadSessions() (UploadSessionList, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return UploadSessionList{Sessions: slices.Clone(s.uploadSessions.Sessions)}, nil
+}
+
+func (s *memoryStore) UpdateUploadSessions(fn func(*UploadSessionList) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return updateMemory(&s.uploadSessions, fn, func(l UploadSessionList) UploadSessionList {
+		return UploadSessionList{Sessions: slices.Clone(l.Sessions)}
+	})
+}
+
+func (s *memoryStore) Settings() (settingsData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.settings, nil
+}
+
+func (s *memoryStore) UpdateSettings(fn func(*settingsData) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return updateMemory(&s.settings, fn, func(st settingsData) settingsData {
+		st.URLSchemes = slices.Clone(st.URLSchemes)
+		st.URLAllowDomains = slices.Clone(st.URLAllowDomains)
+		st.URLDenyDomains = slices.Clone(st.URLDenyDomains)
+		st.Rules = slices.Clone(st.Rules)
+		return st
+	})
+}
+
+func (s *memoryStore) History() (TransferHistory, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return TransferHistory{Records: slices.Clone(s.history.Records)}, nil
+}
+
+func (s *memoryStore) UpdateHistory(fn func(*TransferHistory) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return updateMemory(&s.history, fn, func(h TransferHistory) TransferHistory {
+		return TransferHistory{Records: slices.Clone(h.Records)}
+	})
+}
+
+// [store] Modify a copy of the stored value with 'fn' and keep it only if 'fn' succeeds
+func updateMemory[T any](stored *T, fn func(*T) error, clone func(T) T) error {
+	data := clone(*stored)
+
+	err := fn(&data)
+	if err != nil {
+		return err
+	}
+
+	*stored = data
+
+	return nil
+}
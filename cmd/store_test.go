@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreUpdate(t *testing.T) {
+	store := newMemoryStore(settingsData{})
+
+	err := store.UpdateDevices(func(list *DeviceList) error {
+		list.Devices = append(list.Devices, DeviceInfo{Name: "phone", Identifier: "dev1"})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed update must not change anything, even what 'fn' modified before failing
+	failure := errors.New("failed")
+	err = store.UpdateDevices(func(list *DeviceList) error {
+		list.Devices[0].Name = "changed"
+		list.Devices = append(list.Devices, DeviceInfo{Identifier: "dev2"})
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("got error %v, want %v", err, failure)
+	}
+
+	devices, err := store.Devices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices.Devices) != 1 || devices.Devices[0].Name != "phone" {
+		t.Fatalf("got devices %+v, want only the saved phone", devices.Devices)
+	}
+
+	// The returned list is a copy
+	devices.Devices[0].Name = "changed"
+	devices, _ = store.Devices()
+	if devices.Devices[0].Name != "phone" {
+		t.Errorf("modifying the returned devices changed the store")
+	}
+}
+
+func TestMemoryStoreSettingsCopy(t *testing.T) {
+	store := newMemoryStore(settingsData{URLSchemes: []string{"https"}})
+
+	err := store.UpdateSettings(func(st *settingsData) error {
+		st.URLSchemes[0] = "ftp"
+		return errors.New("failed")
+	})
+	if err == nil {
+		t.Fatal("got no error")
+	}
+
+	st, _ := store.Settings()
+	if st.URLSchemes[0] != "https" {
+		t.Errorf("got schemes %v after a failed update, want [https]", st.URLSchemes)
+	}
+}
+
+func TestLockStoreFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "devices.json")
+
+	// Shared locks do not block each other
+	unlockA, err := lockStoreFile(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlockB, err := lockStoreFile(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An update waits for the readers, like another process would
+	locked := make(chan struct{})
+	go func() {
+		unlock, err := lockStoreFile(path, true)
+		if err == nil {
+			unlock()
+		}
+		close(locked)
+	}()
+
+	unlockA()
+	select {
+	case <-locked:
+		t.Fatal("got the exclusive lock while the file was being read")
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	unlockB()
+	select {
+	case <-locked:
+	case <-time.After(time.Second * 5):
+		t.Fatal("did not get the exclusive lock once the file was read")
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Functions available in the templates
+var templateFuncs = template.FuncMap{
+	"mb": func(bytes int64) int64 { return bytes / (1 << 20) },
+	"join": strings.Join,
+	"date": formatDate,
+	"bytes": formatBytes,
+}
+
+// [templates] Format a time for the pages, the zero time is shown as "never"
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+// [templates] Format a number of bytes for the pages, eg. 1536 becomes "1.5 KB"
+func formatBytes(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	size := float64(n)
+	for _, unit := range []string{"KB", "MB", "GB"} {
+		size /= 1024
+		if size < 1024 || unit == "GB" {
+			return fmt.Sprintf("%.1f %s", size, unit)
+		}
+	}
+
+	return ""
+}
+
+// [templates] Parse every page in the html folder of a given file system, keyed by the page name (eg. "settings")
+func newTemplateCache(fsys fs.FS) (map[string]*template.Template, error) {
+	cache := map[string]*template.Template{}
+
+	pages, err := fs.Glob(fsys, "html/*.html")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, page := range pages {
+		name := strings.TrimSuffix(filepath.Base(page), ".html")
+
+		ts, err := parseTemplate(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+
+		cache[name] = ts
+	}
+
+	return cache, nil
+}
+
+// [templates] Parse a page with a given name from the html folder of a given file system
+func parseTemplate(fsys fs.FS, name string) (*template.Template, error) {
+	return template.New(name+".html").Funcs(templateFuncs).ParseFS(fsys, path.Join("html", name+".html"))
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// How often the progress of a transfer is pushed to the open pages
+const TRANSFER_EVENT_INTERVAL time.Duration = time.Millisecond * 500
+
+// transfer is an upload that is being received. The chunks of an upload session are one transfer, which is kept
+// between their requests
+type transfer struct {
+	id					string
+	deviceId		string
+	deviceName	string
+	total				int64		// -1 when the size is unknown
+	startedAt		time.Time
+	received		atomic.Int64
+	cancelled		atomic.Bool
+
+	mu					sync.Mutex
+	fileName		string
+	eventAt			time.Time
+	requestAt		time.Time	// when the current request started, zero between the chunks of an upload session
+	base				int64			// bytes received before the current request
+	interrupt		func()		// stops reading the body of the current request
+}
+
+// transferStatus is the progress of a transfer as shown by /transfers
+type transferStatus struct {
+	Id					string		`json:"id"`
+	DeviceId		string		`json:"device_id"`
+	DeviceName	string		`json:"device_name"`
+	FileName		string		`json:"file_name"`
+	Total				int64			`json:"total"`
+	Received		int64			`json:"received"`
+	Rate				int64			`json:"rate"`	// bytes per second
+	StartedAt		time.Time	`json:"started_at"`
+}
+
+// transfers keeps the uploads that are being received, so their progress can be shown and they can be cancelled
+type transfers struct {
+	mu					sync.Mutex
+	transfers		map[string]*transfer
+	events			*eventBroker
+}
+
+// [transfers] Create an empty list of transfers that pushes their progress through 'events'
+func newTransfers(events *eventBroker) *transfers {
+	return &transfers{transfers: map[string]*transfer{}, events: events}
+}
+
+// [transfers] Start tracking the upload of a request. 'id' is the upload session of a chunk, so the chunks continue
+// the same transfer, or empty for an upload in a single request. 'received' is how much of it was received before
+// (eg. by earlier chunks). The transfer must be finished, or paused between chunks, when the request is done
+func (ts *transfers) start(w http.ResponseWriter, id, deviceId, deviceName, fileName string, total, received int64) *transfer {
+	if id == "" {
+		id = uuid.NewString()
+	}
+	now := time.Now()
+
+	ts.mu.Lock()
+	t, ok := ts.transfers[id]
+	if !ok {
+		t = &transfer{
+			id: id,
+			deviceId: deviceId,
+			deviceName: deviceName,
+			total: total,
+			startedAt: now,
+			fileName: fileName,
+		}
+		ts.transfers[id] = t
+	}
+	ts.mu.Unlock()
+
+	rc := http.NewResponseController(w)
+	t.mu.Lock()
+	t.requestAt = now
+	t.base = received
+	t.interrupt = func() {
+		rc.SetReadDeadline(time.Now())
+	}
+	t.mu.Unlock()
+	t.received.Store(received)
+
+	ts.events.publish(EVENT_TRANSFER, t.status().toMap())
+
+	return t
+}
+
+// [transfers] Stop tracking a transfer once its upload is done
+func (ts *transfers) finish(t *transfer) {
+	ts.mu.Lock()
+	delete(ts.transfers, t.id)
+	ts.mu.Unlock()
+
+	ts.events.publish(EVENT_TRANSFER_DONE, map[string]any {"id": t.id, "cancelled": t.cancelled.Load()})
+}
+
+// [transfers] Keep a transfer once the request of a chunk is done, until the next chunk continues it
+func (ts *transfers) pause(t *transfer) {
+	t.mu.Lock()
+	t.requestAt = time.Time{}
+	t.interrupt = nil
+	t.mu.Unlock()
+
+	ts.mu.Lock()
+	_, ok := ts.transfers[t.id]
+	ts.mu.Unlock()
+
+	if ok {
+		ts.events.publish(EVENT_TRANSFER, t.status().toMap())
+	}
+}
+
+// [transfers] Stop tracking the transfer with identifier 'id' if there is one, eg. when its upload session ends
+func (ts *transfers) remove(id string) {
+	ts.mu.Lock()
+	t, ok := ts.transfers[id]
+	ts.mu.Unlock()
+
+	if ok {
+		ts.finish(t)
+	}
+}
+
+// [transfers] Cancel a transfer, its request fails with ErrTransferCancelled. A transfer paused between chunks has
+// no request, so it is removed right away. False is returned if it is not found
+func (ts *transfers) cancel(id string) bool {
+	ts.mu.Lock()
+	t, ok := ts.transfers[id]
+	ts.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	t.cancelled.Store(true)
+
+	t.mu.Lock()
+	interrupt := t.interrupt
+	t.mu.Unlock()
+
+	if interrupt != nil {
+		interrupt()
+	} else {
+		ts.finish(t)
+	}
+
+	return true
+}
+
+// [transfers] Get the progress of the transfers, oldest first
+func (ts *transfers) list() []transferStatus {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	list := []transferStatus{}
+	for _, t := range ts.transfers {
+		list = append(list, t.status())
+	}
+	slices.SortFunc(list, func(a, b transferStatus) int {
+		if c := a.StartedAt.Compare(b.StartedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Id, b.Id)
+	})
+
+	return list
+}
+
+// [transfers] Get the progress of a transfer, the rate is of the bytes received by the current request only
+func (t *transfer) status() transferStatus {
+	t.mu.Lock()
+	fileName := t.fileName
+	requestAt := t.requestAt
+	base := t.base
+	t.mu.Unlock()
+
+	received := t.received.Load()
+	rate := int64(0)
+	if elapsed := time.Since(requestAt).Seconds(); !requestAt.IsZero() && elapsed > 0 {
+		rate = int64(float64(received - base) / elapsed)
+	}
+
+	return transferStatus{
+		Id: t.id,
+		DeviceId: t.deviceId,
+		DeviceName: t.deviceName,
+		FileName: fileName,
+		Total: t.total,
+		Received: received,
+		Rate: rate,
+		StartedAt: t.startedAt,
+	}
+}
+
+// [transfers] Set the name of the file being received, eg. for each file of a multipart upload
+func (t *transfer) setFileName(name string) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	t.fileName = name
+	t.mu.Unlock()
+}
+
+// [transfers] Wrap a request body to count the bytes received by a transfer, and to fail with ErrTransferCancelled
+// once it is cancelled
+func (ts *transfers) reader(t *transfer, body io.ReadCloser) io.ReadCloser {
+	return &transferReader{r: body, t: t, events: ts.events}
+}
+
+// [transfers] Get a transfer status as an event payload
+func (s transferStatus) toMap() map[string]any {
+	return map[string]any {
+		"id": s.Id,
+		"device_id": s.DeviceId,
+		"device_name": s.DeviceName,
+		"file_name": s.FileName,
+		"total": s.Total,
+		"received": s.Received,
+		"rate": s.Rate,
+	}
+}
+
+// transferReader counts the bytes read from a request body and pushes the progress now and then
+type transferReader struct {
+	r				io.ReadCloser
+	t				*transfer
+	events	*eventBroker
+}
+
+func (tr *transferReader) Read(p []byte) (int, error) {
+	if tr.t.cancelled.Load() {
+		return 0, ErrTransferCancelled
+	}
+
+	n, err := tr.r.Read(p)
+	tr.t.received.Add(int64(n))
+
+	if tr.t.cancelled.Load() {
+		return n, ErrTransferCancelled
+	}
+
+	tr.t.mu.Lock()
+	push := time.Since(tr.t.eventAt) >= TRANSFER_EVENT_INTERVAL
+	if push {
+		tr.t.eventAt = time.Now()
+	}
+	tr.t.mu.Unlock()
+
+	if push {
+		tr.events.publish(EVENT_TRANSFER, tr.t.status().toMap())
+	}
+
+	return n, err
+}
+
+func (tr *transferReader) Close() error {
+	return tr.r.Close()
+}
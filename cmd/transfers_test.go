@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// [tests] Get the transfers listed by /transfers
+func listTransfers(t *testing.T, app *application) []transferStatus {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	app.getTransfers(w, httptest.NewRequest(http.MethodGet, "/transfers", nil))
+
+	var body struct {
+		Transfers	[]transferStatus	`json:"transfers"`
+	}
+	err := json.Unmarshal(w.Body.Bytes(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return body.Transfers
+}
+
+// [tests] Wait until a transfer has received 'want' bytes
+func waitReceived(t *testing.T, app *application, want int64) transferStatus {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second * 5)
+	for time.Now().Before(deadline) {
+		list := listTransfers(t, app)
+		if len(list) == 1 && list[0].Received == want {
+			return list[0]
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	t.Fatalf("got transfers %+v, want one that received %d bytes", listTransfers(t, app), want)
+	return transferStatus{}
+}
+
+func TestTransferProgressAndCancel(t *testing.T) {
+	app, _ := newTestApp(settingsData{})
+	app.config.dataDir = t.TempDir()
+	app.store.(*memoryStore).devices.Devices = []DeviceInfo{{Name: "phone", Identifier: "dev1"}}
+
+	session, secret := newTestUploadSession(t, app, 10, "12")
+
+	// Send a chunk that stops halfway, like a slow connection
+	body, pw := io.Pipe()
+	r := httptest.NewRequest(http.MethodPut, "/upload/session/"+session.Id, body)
+	r = r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, httprouter.Params{{Key: "id", Value: session.Id}}))
+	r.SetBasicAuth("dev1", secret)
+	r.Header.Set("Upload-Offset", "2")
+
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		app.uploadChunk(w, r)
+	}()
+
+	pw.Write([]byte("345"))
+
+	// The transfer counts the bytes of the earlier chunks
+	status := waitReceived(t, app, 5)
+	if status.Id != session.Id || status.DeviceName != "phone" || status.FileName != "video.mov" || status.Total != 10 {
+		t.Errorf("got transfer %+v, want the upload session of phone", status)
+	}
+
+	w2 := postForm(app.cancelTransfer, url.Values{"id": {session.Id}})
+	if w2.Code != http.StatusOK {
+		t.Fatalf("got status %d cancelling, want %d: %s", w2.Code, http.StatusOK, w2.Body)
+	}
+
+	// The next bytes fail the request of the chunk
+	go pw.Write([]byte("678"))
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		pw.CloseWithError(errors.New("timeout"))
+		t.Fatal("the cancelled chunk was still being received")
+	}
+	if w.Code != http.StatusConflict {
+		t.Errorf("got status %d for the cancelled chunk, want %d: %s", w.Code, http.StatusConflict, w.Body)
+	}
+
+	// The transfer and its upload session are gone, so the device cannot continue it
+	if list := listTransfers(t, app); len(list) != 0 {
+		t.Errorf("got transfers %+v after cancelling, want none", list)
+	}
+	if _, err := getUploadSession(app.store, session.Id); !errors.Is(err, ErrUploadSessionNotFound) {
+		t.Errorf("got error %v getting the cancelled session, want %v", err, ErrUploadSessionNotFound)
+	}
+	if _, err := os.Stat(uploadPartPath(app.config.uploadPartsDir(), session.Id)); !os.IsNotExist(err) {
+		t.Errorf("got error %v for the partial file, want it removed", err)
+	}
+
+	if w := postForm(app.cancelTransfer, url.Values{"id": {session.Id}}); w.Code != http.StatusNotFound {
+		t.Errorf("got status %d cancelling again, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestTransferBetweenChunks(t *testing.T) {
+	app, _ := newTestApp(settingsData{})
+	app.config.dataDir = t.TempDir()
+	app.store.(*memoryStore).devices.Devices = []DeviceInfo{{Name: "phone", Identifier: "dev1"}}
+
+	session, secret := newTestUploadSession(t, app, 10, "")
+
+	w := sessionRequest(app.uploadChunk, http.MethodPut, session.Id, secret, "12345", map[string]string{"Upload-Offset": "0"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	// The transfer is kept until the next chunk, without a rate since nothing is being received
+	list := listTransfers(t, app)
+	if len(list) != 1 || list[0].Received != 5 || list[0].Rate != 0 {
+		t.Fatalf("got transfers %+v, want the paused transfer", list)
+	}
+	startedAt := list[0].StartedAt
+
+	// The next chunk continues the same transfer
+	w = sessionRequest(app.uploadChunk, http.MethodPut, session.Id, secret, "678", map[string]string{"Upload-Offset": "5"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	list = listTransfers(t, app)
+	if len(list) != 1 || list[0].Received != 8 || !list[0].StartedAt.Equal(startedAt) {
+		t.Fatalf("got transfers %+v, want one transfer that received 8 bytes", list)
+	}
+
+	// Cancelling it between chunks ends it right away
+	if w := postForm(app.cancelTransfer, url.Values{"id": {session.Id}}); w.Code != http.StatusOK {
+		t.Errorf("got status %d cancelling, want %d", w.Code, http.StatusOK)
+	}
+	if list := listTransfers(t, app); len(list) != 0 {
+		t.Errorf("got transfers %+v after cancelling, want none", list)
+	}
+
+	w = sessionRequest(app.uploadChunk, http.MethodPut, session.Id, secret, "90", map[string]string{"Upload-Offset": "8"})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got status %d continuing the cancelled upload, want %d", w.Code, http.StatusNotFound)
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"html/template"
+	"io/fs"
 	"log"
 	"net"
 	"time"
@@ -19,11 +21,24 @@ type HostInfo struct {
 }
 
 type application struct {
+	config				config
 	infoLog				*log.Logger
 	errorLog			*log.Logger
 	formDecoder		*form.Decoder
 	hostInfo			HostInfo
+	tlsFingerprint	string
+	pairingCodes	*pairingCodes
+	challenges		*connectChallenges
+	pendingURLs		*pendingURLs
+	limiter				*rateLimiter
+	adminSessions	*adminSessions
+	events				*eventBroker
+	transfers			*transfers
 	mDNSSvc				*zeroconf.Server
+	store					Store
+	platform			Platform
+	uiFS					fs.FS
+	templateCache	map[string]*template.Template
 }
 
 
@@ -32,6 +47,35 @@ type application struct {
 type DeviceInfo struct {
 	Name 					string	`json:"name"`
 	Identifier		string	`json:"identifier"`
+	PublicKey			string	`json:"public_key,omitempty"`	// base64 Ed25519 public key
+	Permissions		*DevicePermissions	`json:"permissions,omitempty"`	// nil allows everything
+	Activity			DeviceActivity	`json:"activity"`
+}
+
+type DeviceActivity struct {
+	RequestedAt			time.Time	`json:"requested_at"`			// when it was added to the pending list
+	ApprovedAt			time.Time	`json:"approved_at"`
+	LastConnectAt		time.Time	`json:"last_connect_at"`
+	LastUploadAt		time.Time	`json:"last_upload_at"`
+	IPs							[]string	`json:"ips"`						// latest last, up to MAX_DEVICE_IPS
+	Uploads					int				`json:"uploads"`
+	BytesReceived		int64			`json:"bytes_received"`
+}
+
+type AuditEvent struct {
+	Time				time.Time	`json:"time"`
+	Action			string		`json:"action"`				// approve, deny, remove or expire
+	DeviceId		string		`json:"device_id"`
+	DeviceName	string		`json:"device_name"`
+	By					string		`json:"by"`						// "cli" or the IP address of the local UI user
+}
+
+type DevicePermissions struct {
+	Files					bool			`json:"files"`
+	URL						bool			`json:"url"`
+	Text					bool			`json:"text"`
+	MaxSize				int64			`json:"max_size"`		// bytes per file, 0 means no limit
+	Extensions		[]string	`json:"extensions"`	// allowed file extensions, empty allows all
 }
 
 type DeviceList struct {
@@ -41,6 +85,7 @@ type DeviceList struct {
 type DeviceInfoForm struct {
 	Name 					string	`form:"name"`
 	Identifier		string	`form:"identifier"`
+	PublicKey			string	`form:"key"`
 }
 
 
@@ -48,8 +93,11 @@ type DeviceInfoForm struct {
 
 type Token struct {
 	DeviceId		string
-	Secret			string		`json:"secret"`
+	Salt				string		`json:"salt"`
+	Hash				string		`json:"hash"`		// salted SHA-256 of the secret, the secret itself is never saved
 	ExpiredAt		time.Time	`json:"expired_at"`
+	MaxUses			int				`json:"max_uses"`	// 0 means no limit until it expires
+	Uses				int				`json:"uses"`
 }
 
 type TokenList struct {
@@ -57,11 +105,70 @@ type TokenList struct {
 }
 
 
+/* --- UPLOAD SESSIONS --- */
+
+type UploadSession struct {
+	Id					string		`json:"id"`
+	DeviceId		string		`json:"device_id"`
+	SecretSalt	string		`json:"secret_salt"`
+	SecretHash	string		`json:"secret_hash"`
+	FileName		string		`json:"file_name"`
+	Size				int64			`json:"size"`
+	Offset			int64			`json:"offset"`
+	ExpiredAt		time.Time	`json:"expired_at"`
+}
+
+type UploadSessionList struct {
+	Sessions	[]UploadSession	`json:"sessions"`
+}
+
+type uploadedContent struct {
+	Values		map[string]string
+	Saved			[]savedFile
+	Skipped		[]string
+	Bytes			int64			// size of the values and the files received
+}
+
+type savedFile struct {
+	Name		string	// sanitized name sent by the device
+	Path		string	// where it was saved, the name may differ after a rename
+	Size		int64
+}
+
+type uploadSessionForm struct {
+	Name		string	`form:"name"`
+	Size		int64		`form:"size"`
+}
+
+
+/* --- TRANSFER HISTORY --- */
+
+type TransferRecord struct {
+	Id					string		`json:"id"`
+	Time				time.Time	`json:"time"`
+	DeviceId		string		`json:"device_id"`
+	DeviceName	string		`json:"device_name"`
+	Kind				string		`json:"kind"`		// url, text or file
+	Value				string		`json:"value"`	// the URL, the text or the file name
+	Path				string		`json:"path,omitempty"`	// where the file was saved
+	Truncated		bool			`json:"truncated,omitempty"`	// only the start of a long text is kept
+	Size				int64			`json:"size,omitempty"`	// bytes of the file or the text
+	Status			string		`json:"status"`
+}
+
+type TransferHistory struct {
+	Records		[]TransferRecord	`json:"records"`
+}
+
+
 /* --- DEVICES FORMS --- */
 
 type deviceData struct {
+	CSRFToken	string
 	Pending	DeviceList
 	Saved		DeviceList
+	Blocked	[]blockedClient
+	Audit		[]AuditEvent	// latest first
 }
 
 type verifyPostForm struct {
@@ -73,18 +180,137 @@ type removeDeviceForm struct {
 	Id			string 	`form:"id"`
 }
 
+type unblockForm struct {
+	Key			string	`form:"key"`
+}
+
+type permissionsPostForm struct {
+	Id					string	`form:"id"`
+	Files				bool		`form:"files"`
+	URL					bool		`form:"url"`
+	Text				bool		`form:"text"`
+	MaxSizeMB		int64		`form:"maxSize"`
+	Extensions	string	`form:"extensions"`
+}
+
+
+/* --- HISTORY FORMS --- */
+
+type historyData struct {
+	CSRFToken	string
+	Query			string
+	Kind			string
+	Records		[]TransferRecord	// latest first
+}
+
+type historyActionForm struct {
+	Id			string	`form:"id"`
+}
+
+
+/* --- LOGIN FORMS --- */
+
+type loginData struct {
+	CSRFToken	string
+}
+
+type loginPostForm struct {
+	Password	string	`form:"password"`
+}
+
 
 /* --- SETTINGS FORMS --- */
 
 type settingsData struct {
-	Dst    			string `json:"destination"`
+	Dst    					string	`json:"destination"`
+	MaxFileSize			int64		`json:"max_file_size"`		// bytes, 0 means no limit
+	MaxRequestSize	int64		`json:"max_request_size"`	// bytes, 0 means no limit
+	OnConflict			string	`json:"on_conflict"`			// rename, overwrite or skip
+	TokenMode				string	`json:"token_mode"`				// single (one upload per connection) or session
+	SessionMinutes	int			`json:"session_minutes"`
+	SessionUploads	int			`json:"session_uploads"`	// 0 means no limit
+	URLSchemes			[]string	`json:"url_schemes"`				// empty allows http and https
+	URLAllowDomains	[]string	`json:"url_allow_domains"`	// empty allows every domain that is not denied
+	URLDenyDomains	[]string	`json:"url_deny_domains"`
+	URLConfirm			bool			`json:"url_confirm"`				// ask on this PC before opening a URL
+	DeviceExpiryDays		int		`json:"device_expiry_days"`		// days after approval, 0 means never
+	DeviceInactiveDays	int		`json:"device_inactive_days"`	// days without connecting, 0 means never
+	AdminPasswordHash	string	`json:"admin_password_hash,omitempty"`	// bcrypt, empty allows only this PC without a password
+	Rules						[]RoutingRule	`json:"rules"`	// the first rule a file matches decides its folder
+}
+
+type RoutingRule struct {
+	Id					string		`json:"id"`
+	Device			string		`json:"device"`			// name or identifier of the sender, empty matches every device
+	Extensions	[]string	`json:"extensions"`	// empty matches every extension
+	MimeType		string		`json:"mime_type"`	// eg. image/png or image/*, empty matches every type
+	Pattern			string		`json:"pattern"`		// file name pattern, eg. IMG_*
+	MinSize			int64			`json:"min_size"`		// bytes, 0 means no minimum
+	MaxSize			int64			`json:"max_size"`		// bytes, 0 means no maximum
+	Path				string		`json:"path"`				// folder to save in, eg. {dst}/{device}/{yyyy}-{mm}
 }
 
 type settingsForm struct {
+	CSRFToken		string
+	HasPassword	bool
 	QRCodeData 	string
+	PairingTTL	int	// seconds
 	Dst    			string
+	OnConflict	string
+	TokenMode				string
+	SessionMinutes	int
+	SessionUploads	int
+	URLSchemes			string
+	URLAllowDomains	string
+	URLDenyDomains	string
+	URLConfirm			bool
+	DeviceExpiryDays		int
+	DeviceInactiveDays	int
+	MaxFileSizeMB				int64
+	MaxRequestSizeMB		int64
+	Rules				[]RoutingRule
+}
+
+type ruleForm struct {
+	Device			string	`form:"device"`
+	Extensions	string	`form:"extensions"`
+	MimeType		string	`form:"mimeType"`
+	Pattern			string	`form:"pattern"`
+	MinSizeMB		int64		`form:"minSize"`
+	MaxSizeMB		int64		`form:"maxSize"`
+	Path				string	`form:"path"`
+}
+
+type ruleIdForm struct {
+	Id			string	`form:"id"`
+}
+
+type pendingURLsData struct {
+	CSRFToken	string
+	URLs	[]pendingURL
+}
+
+type cancelTransferForm struct {
+	Id			string	`form:"id"`
+}
+
+type pendingURLForm struct {
+	Id			string	`form:"id"`
+	Open		bool		`form:"open"`
 }
 
 type settingsPostForm struct {
-	Dst		string `form:"dst"`
+	Dst							string	`form:"dst"`
+	OnConflict			string	`form:"conflict"`
+	TokenMode				string	`form:"tokenMode"`
+	SessionMinutes	int			`form:"sessionMinutes"`
+	SessionUploads	int			`form:"sessionUploads"`
+	URLSchemes			string	`form:"urlSchemes"`
+	URLAllowDomains	string	`form:"urlAllow"`
+	URLDenyDomains	string	`form:"urlDeny"`
+	URLConfirm			bool		`form:"urlConfirm"`
+	DeviceExpiryDays		int	`form:"deviceExpiry"`
+	DeviceInactiveDays	int	`form:"deviceInactive"`
+	MaxFileSizeMB				int64	`form:"maxFileSize"`
+	MaxRequestSizeMB		int64	`form:"maxRequestSize"`
 }
\ No newline at end of file
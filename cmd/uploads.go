@@ -0,0 +1,163 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// [uploads] Get an upload session with identifier 'id'
+func getUploadSession(store Store, id string) (UploadSession, error) {
+	sessions, err := store.UploadSessions()
+	if err != nil {
+		return UploadSession{}, err
+	}
+
+	for _, s := range sessions.Sessions {
+		if s.Id == id {
+			return s, nil
+		}
+	}
+
+	return UploadSession{}, ErrUploadSessionNotFound
+}
+
+// [uploads] Create a new upload session or update an existing one
+func saveUploadSession(store Store, session UploadSession) error {
+	return store.UpdateUploadSessions(func(sessions *UploadSessionList) error {
+		newSessions := []UploadSession{session}
+		for _, s := range sessions.Sessions {
+			if s.Id != session.Id {
+				newSessions = append(newSessions, s)
+			}
+		}
+		sessions.Sessions = newSessions
+		return nil
+	})
+}
+
+// [uploads] Remove all expired upload sessions and their partial files in a given folder, and get their identifiers
+func purgeExpiredUploadSessions(store Store, dir string) (purged []string, err error) {
+	err = store.UpdateUploadSessions(func(sessions *UploadSessionList) error {
+		now := time.Now()
+		newSessions := []UploadSession{}
+		for _, s := range sessions.Sessions {
+			if now.After(s.ExpiredAt) {
+				os.Remove(uploadPartPath(dir, s.Id))
+				forgetFileLock(uploadPartPath(dir, s.Id))
+				purged = append(purged, s.Id)
+				continue
+			}
+			newSessions = append(newSessions, s)
+		}
+		sessions.Sessions = newSessions
+		return nil
+	})
+
+	return purged, err
+}
+
+// [uploads] Remove an upload session with identifier 'id' and its partial file in a given folder
+func deleteUploadSession(store Store, dir string, id string) error {
+	err := store.UpdateUploadSessions(func(sessions *UploadSessionList) error {
+		newSessions := []UploadSession{}
+		for _, s := range sessions.Sessions {
+			if s.Id != id {
+				newSessions = append(newSessions, s)
+			}
+		}
+		sessions.Sessions = newSessions
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	forgetFileLock(uploadPartPath(dir, id))
+	err = os.Remove(uploadPartPath(dir, id))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// [uploads] Get the path of the partial file of an upload session in a given folder
+func uploadPartPath(dir string, id string) string {
+	return filepath.Join(dir, id+".part")
+}
+
+// [uploads] Create an empty partial file for a new upload session in a given folder
+func createUploadPart(dir string, id string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(uploadPartPath(dir, id))
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// [uploads] Write a chunk at the current offset of an upload session, the number of written bytes is returned even on failure
+func writeUploadChunk(dir string, session UploadSession, chunk io.Reader) (int64, error) {
+	f, err := os.OpenFile(uploadPartPath(dir, session.Id), os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	_, err = f.Seek(session.Offset, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+
+	// Read one more byte than allowed to detect chunks that exceed the declared size
+	remaining := session.Size - session.Offset
+	n, err := io.Copy(f, io.LimitReader(chunk, remaining+1))
+	if n > remaining {
+		f.Truncate(session.Size)
+		return remaining, ErrUploadTooLarge
+	}
+
+	return n, err
+}
+
+// [uploads] Move a file to a new path, falling back to copying when renaming is not possible (eg. across drives)
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	// The source is only removed once the copy is known to be on disk
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+
+	in.Close()
+
+	return os.Remove(src)
+}
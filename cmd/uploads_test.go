@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// [tests] Create an upload session of a saved device with its secret, and a partial file with 'received' in it
+func newTestUploadSession(t *testing.T, app *application, size int64, received string) (UploadSession, string) {
+	t.Helper()
+
+	err := os.MkdirAll(app.config.uploadPartsDir(), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := "session-secret"
+	salt, hash, err := hashSecret(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := UploadSession{
+		Id: "session1",
+		DeviceId: "dev1",
+		SecretSalt: salt,
+		SecretHash: hash,
+		FileName: "video.mov",
+		Size: size,
+		Offset: int64(len(received)),
+		ExpiredAt: time.Now().Add(time.Hour),
+	}
+
+	err = os.WriteFile(uploadPartPath(app.config.uploadPartsDir(), session.Id), []byte(received), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = saveUploadSession(app.store, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return session, secret
+}
+
+// [tests] Send a request to an upload session handler, authenticated with the secret of the session
+func sessionRequest(handler http.HandlerFunc, method, id, secret, body string, header map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, "/upload/session/"+id, strings.NewReader(body))
+	r = r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, httprouter.Params{{Key: "id", Value: id}}))
+	r.SetBasicAuth("dev1", secret)
+	for key, value := range header {
+		r.Header.Set(key, value)
+	}
+
+	w := httptest.NewRecorder()
+	handler(w, r)
+
+	return w
+}
+
+func TestFinalizeUploadSessionOnce(t *testing.T) {
+	dst := t.TempDir()
+	app, _ := newTestApp(settingsData{Dst: dst, OnConflict: CONFLICT_RENAME})
+	app.config.dataDir = t.TempDir()
+	app.store.(*memoryStore).devices.Devices = []DeviceInfo{{Name: "phone", Identifier: "dev1"}}
+
+	session, secret := newTestUploadSession(t, app, 5, "12345")
+
+	// Both requests are authorized, but only one can move the file
+	var wg sync.WaitGroup
+	statuses := make([]int, 2)
+	for i := range statuses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := sessionRequest(app.finalizeUploadSession, http.MethodPost, session.Id, secret, "", nil)
+			statuses[i] = w.Code
+		}()
+	}
+	wg.Wait()
+
+	slices.Sort(statuses)
+	if statuses[0] != http.StatusOK || statuses[1] != http.StatusNotFound {
+		t.Errorf("got statuses %v, want one %d and one %d", statuses, http.StatusOK, http.StatusNotFound)
+	}
+
+	entries, _ := os.ReadDir(dst)
+	if len(entries) != 1 || entries[0].Name() != "video.mov" {
+		t.Errorf("got %v in the destination folder, want only video.mov", entries)
+	}
+
+	// The lock of the ended session is forgotten
+	if _, ok := fileLocks.Load(fileLockKey(uploadPartPath(app.config.uploadPartsDir(), session.Id))); ok {
+		t.Errorf("the lock of the finalized session is kept")
+	}
+}
+
+func TestUploadChunkOffset(t *testing.T) {
+	app, _ := newTestApp(settingsData{})
+	app.config.dataDir = t.TempDir()
+	app.store.(*memoryStore).devices.Devices = []DeviceInfo{{Name: "phone", Identifier: "dev1"}}
+
+	session, secret := newTestUploadSession(t, app, 10, "")
+
+	// The same chunk sent twice is written once, the second one is told where to continue
+	for _, want := range []int{http.StatusOK, http.StatusConflict} {
+		w := sessionRequest(app.uploadChunk, http.MethodPut, session.Id, secret, "12345", map[string]string{"Upload-Offset": "0"})
+		if w.Code != want {
+			t.Errorf("got status %d, want %d: %s", w.Code, want, w.Body)
+		}
+		if w.Header().Get("Upload-Offset") != "5" {
+			t.Errorf("got offset %q, want 5", w.Header().Get("Upload-Offset"))
+		}
+	}
+
+	data, _ := os.ReadFile(uploadPartPath(app.config.uploadPartsDir(), session.Id))
+	if string(data) != "12345" {
+		t.Errorf("got partial file %q, want 12345", data)
+	}
+}
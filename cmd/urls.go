@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const PENDING_URL_TTL time.Duration = time.Minute * 10
+
+// urlError is returned when a URL sent by a device does not pass the URL policy
+type urlError struct {
+	reason	string
+}
+
+func (e urlError) Error() string {
+	return "urls: " + e.reason
+}
+
+func (e urlError) Unwrap() error {
+	return ErrURLNotAllowed
+}
+
+// [urls] Get the URL schemes allowed by the settings, http and https are allowed when none are set
+func urlSchemes(st settingsData) []string {
+	if len(st.URLSchemes) == 0 {
+		return []string{"http", "https"}
+	}
+
+	return st.URLSchemes
+}
+
+// [urls] Check a URL sent by a device against the URL policy in the settings.
+// The normalized URL is returned, so the opener never receives anything but a parsed absolute URL
+func checkURL(raw string, st settingsData) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || u.Scheme == "" {
+		return "", urlError{"it is not a valid URL"}
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	if !slices.Contains(urlSchemes(st), scheme) {
+		return "", urlError{fmt.Sprintf("the %s scheme is not allowed", scheme)}
+	}
+
+	// The domain lists only apply to URLs with a host, eg. not to mailto: URLs
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		if (scheme == "http" || scheme == "https") || len(st.URLAllowDomains) > 0 {
+			return "", urlError{"the URL has no host"}
+		}
+		return u.String(), nil
+	}
+
+	if matchDomain(host, st.URLDenyDomains) {
+		return "", urlError{fmt.Sprintf("%s is blocked", host)}
+	}
+	if len(st.URLAllowDomains) > 0 && !matchDomain(host, st.URLAllowDomains) {
+		return "", urlError{fmt.Sprintf("%s is not in the allowed domains", host)}
+	}
+
+	return u.String(), nil
+}
+
+// [urls] Check whether a host is one of the given domains or a subdomain of them
+func matchDomain(host string, domains []string) bool {
+	for _, domain := range domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// [urls] Normalize a domain of the URL policy, eg. "*.Example.com" becomes "example.com"
+func normalizeDomain(domain string) string {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	domain = strings.TrimPrefix(domain, "*")
+	return strings.Trim(domain, ".")
+}
+
+// [urls] Normalize a scheme of the URL policy, eg. "HTTPS://" becomes "https"
+func normalizeScheme(scheme string) string {
+	scheme = strings.ToLower(strings.TrimSpace(scheme))
+	return strings.TrimSuffix(strings.TrimSuffix(scheme, "//"), ":")
+}
+
+// [urls] Set the policy for URLs sent by devices
+func setURLPolicy(store Store, schemes, allowDomains, denyDomains []string, confirm bool) error {
+	return store.UpdateSettings(func(st *settingsData) error {
+		st.URLSchemes = schemes
+		st.URLAllowDomains = allowDomains
+		st.URLDenyDomains = denyDomains
+		st.URLConfirm = confirm
+		return nil
+	})
+}
+
+
+/* --- URLS WAITING FOR CONFIRMATION --- */
+
+type pendingURL struct {
+	Id					string
+	DeviceId		string
+	URL					string
+	ReceivedAt	time.Time
+}
+
+// pendingURLs keeps the URLs that wait to be opened or dismissed on this PC, when the URL policy asks first.
+// They are kept in memory only, so they are dropped when the server restarts
+type pendingURLs struct {
+	mu		sync.Mutex
+	urls	[]pendingURL
+}
+
+// [urls] Create an empty list of URLs waiting for confirmation
+func newPendingURLs() *pendingURLs {
+	return &pendingURLs{}
+}
+
+// [urls] Add a URL sent by a device to the list and get its ID
+func (p *pendingURLs) add(deviceId, url string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.drop()
+	pending := pendingURL{
+		Id: uuid.NewString(),
+		DeviceId: deviceId,
+		URL: url,
+		ReceivedAt: time.Now(),
+	}
+	p.urls = append(p.urls, pending)
+
+	return pending.Id
+}
+
+// [urls] Remove the URL with a given ID from the list and get it
+func (p *pendingURLs) take(id string) (pendingURL, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.drop()
+	for i, pending := range p.urls {
+		if pending.Id == id {
+			p.urls = slices.Delete(p.urls, i, i+1)
+			return pending, true
+		}
+	}
+
+	return pendingURL{}, false
+}
+
+// [urls] Get the URLs waiting for confirmation, oldest first
+func (p *pendingURLs) list() []pendingURL {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.drop()
+	return slices.Clone(p.urls)
+}
+
+// Drop the URLs that have waited for too long, the caller must hold the lock
+func (p *pendingURLs) drop() {
+	now := time.Now()
+	p.urls = slices.DeleteFunc(p.urls, func(pending pendingURL) bool {
+		return now.Sub(pending.ReceivedAt) > PENDING_URL_TTL
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCheckURL(t *testing.T) {
+	defaults := settingsData{}
+	withMailto := settingsData{URLSchemes: []string{"http", "https", "mailto"}}
+	denied := settingsData{URLDenyDomains: []string{"example.com"}}
+	allowed := settingsData{URLSchemes: []string{"https", "mailto"}, URLAllowDomains: []string{"example.com"}}
+	both := settingsData{URLAllowDomains: []string{"example.com"}, URLDenyDomains: []string{"ads.example.com"}}
+
+	tests := []struct {
+		name		string
+		raw			string
+		st			settingsData
+		want		string	// the normalized URL, empty when it is rejected
+	}{
+		// schemes
+		{"http by default", "http://example.com/page", defaults, "http://example.com/page"},
+		{"https by default", "https://example.com/page?q=1#top", defaults, "https://example.com/page?q=1#top"},
+		{"surrounding spaces", "  https://example.com  ", defaults, "https://example.com"},
+		{"upper-case scheme", "HTTPS://example.com", defaults, "https://example.com"},
+		{"javascript", "javascript:alert(1)", defaults, ""},
+		{"file", "file:///C:/Windows/System32/calc.exe", defaults, ""},
+		{"mailto not allowed", "mailto:me@example.com", defaults, ""},
+		{"mailto allowed", "mailto:me@example.com", withMailto, "mailto:me@example.com"},
+		{"scheme not in the list", "http://example.com", allowed, ""},
+		{"no scheme", "example.com/page", defaults, ""},
+		{"not a URL", "http://[::1", defaults, ""},
+		{"http without a host", "http:///page", defaults, ""},
+
+		// deny list
+		{"denied domain", "https://example.com", denied, ""},
+		{"denied subdomain", "https://www.example.com", denied, ""},
+		{"denied with a trailing dot", "https://example.com./page", denied, ""},
+		{"denied in upper case", "https://WWW.Example.COM", denied, ""},
+		{"other domain with the same suffix", "https://badexample.com", denied, "https://badexample.com"},
+		{"other domain", "https://example.org", denied, "https://example.org"},
+
+		// allow list
+		{"allowed domain", "https://example.com", allowed, "https://example.com"},
+		{"allowed subdomain", "https://docs.example.com/a", allowed, "https://docs.example.com/a"},
+		{"allowed in upper case", "https://Docs.EXAMPLE.com", allowed, "https://Docs.EXAMPLE.com"},
+		{"not allowed domain", "https://example.org", allowed, ""},
+		{"not allowed suffix", "https://notexample.com", allowed, ""},
+		{"no host with an allow list", "mailto:me@example.com", allowed, ""},
+
+		// deny wins over allow
+		{"allowed but denied subdomain", "https://ads.example.com", both, ""},
+		{"allowed and not denied", "https://www.example.com", both, "https://www.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := checkURL(tt.raw, tt.st)
+			if tt.want == "" {
+				if !errors.Is(err, ErrURLNotAllowed) {
+					t.Errorf("got %q and error %v, want %v", got, err, ErrURLNotAllowed)
+				}
+				return
+			}
+
+			if err != nil || got != tt.want {
+				t.Errorf("got %q and error %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeURLPolicy(t *testing.T) {
+	domains := map[string]string{
+		"Example.com": "example.com",
+		"*.example.com": "example.com",
+		" .example.com. ": "example.com",
+	}
+	for domain, want := range domains {
+		if got := normalizeDomain(domain); got != want {
+			t.Errorf("normalizeDomain(%q) = %q, want %q", domain, got, want)
+		}
+	}
+
+	schemes := map[string]string{
+		"HTTPS://": "https",
+		"mailto:": "mailto",
+		" ftp ": "ftp",
+	}
+	for scheme, want := range schemes {
+		if got := normalizeScheme(scheme); got != want {
+			t.Errorf("normalizeScheme(%q) = %q, want %q", scheme, got, want)
+		}
+	}
+}
+
+func TestOpenURLConfirm(t *testing.T) {
+	app, platform := newTestApp(settingsData{})
+
+	// Without an open page showing the URLs waiting for confirmation, the page is opened
+	status := app.openURL("dev1", "https://example.com", true)
+	if status != URL_PENDING || len(platform.Calls) != 1 || !strings.HasSuffix(platform.Calls[0].Args[0], "/urls") {
+		t.Errorf("got status %s and calls %+v, want the URL kept and the URLs page opened", status, platform.Calls)
+	}
+
+	// An open page that shows them gets an event instead
+	_, unsubscribe := app.events.subscribe([]string{EVENT_URL_PENDING})
+	defer unsubscribe()
+
+	platform.Calls = nil
+	status = app.openURL("dev1", "https://example.com", true)
+	if status != URL_PENDING || len(platform.Calls) != 0 {
+		t.Errorf("got status %s and calls %+v, want the URL kept without opening a page", status, platform.Calls)
+	}
+
+	status = app.openURL("dev1", "https://example.com", false)
+	if status != URL_OPENED || len(platform.Calls) != 1 || platform.Calls[0].Args[0] != "https://example.com" {
+		t.Errorf("got status %s and calls %+v, want the URL opened", status, platform.Calls)
+	}
+
+	platform.Err = errors.New("no browser")
+	status = app.openURL("dev1", "https://example.com", false)
+	if status != URL_FAILED {
+		t.Errorf("got status %s, want %s", status, URL_FAILED)
+	}
+}
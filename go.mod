@@ -12,6 +12,8 @@ require (
 	github.com/google/uuid v1.6.0
 	github.com/grandcat/zeroconf v1.0.0
 	github.com/justinas/alice v1.2.0
+	golang.org/x/crypto v0.25.0
+	golang.org/x/sys v0.22.0
 )
 
 require (
@@ -20,6 +22,5 @@ require (
 	golang.org/x/mod v0.19.0 // indirect
 	golang.org/x/net v0.27.0 // indirect
 	golang.org/x/sync v0.7.0 // indirect
-	golang.org/x/sys v0.22.0 // indirect
 	golang.org/x/tools v0.23.0 // indirect
 )
@@ -0,0 +1,8 @@
+package ui
+
+import "embed"
+
+// Files holds the HTML templates and static assets, so the binary can run from any folder
+//
+//go:embed "html" "static"
+var Files embed.FS